@@ -0,0 +1,125 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func addAnsibleFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("limit", "l", "", "limit the playbook to a subset of hosts")
+	cmd.Flags().String("tags", "", "only run plays and tasks tagged with these values")
+	cmd.Flags().String("skip-tags", "", "only run plays and tasks whose tags do not match these values")
+	cmd.Flags().StringArrayP("extra-vars", "e", []string{}, "set additional variables as key=value or YAML/JSON")
+	cmd.Flags().StringArray("vars-file", []string{}, "set additional variables from a YAML/JSON file")
+	cmd.Flags().Bool("check", false, "don't make any changes, instead try to predict some of the changes that may occur")
+	cmd.Flags().Bool("diff", false, "show the differences in files and templates being changed")
+	cmd.Flags().CountP("verbose", "v", "tell Ansible to print more debug messages (-vvv for more)")
+}
+
+// ansibleParams converts the shared ansible flags of cmd into ansible-playbook
+// parameters. Commands that do not define the flags produce no parameters.
+func ansibleParams(cmd *cobra.Command) []string {
+	var param []string
+
+	if limit, _ := cmd.Flags().GetString("limit"); len(limit) > 0 {
+		param = append(param, "--limit", limit)
+	}
+
+	if tags, _ := cmd.Flags().GetString("tags"); len(tags) > 0 {
+		param = append(param, "--tags", tags)
+	}
+
+	if skip, _ := cmd.Flags().GetString("skip-tags"); len(skip) > 0 {
+		param = append(param, "--skip-tags", skip)
+	}
+
+	vars, _ := cmd.Flags().GetStringArray("extra-vars")
+
+	for _, v := range vars {
+		param = append(param, "--extra-vars", v)
+	}
+
+	files, _ := cmd.Flags().GetStringArray("vars-file")
+
+	for _, f := range files {
+		param = append(param, "--extra-vars", "@"+f)
+	}
+
+	if check, _ := cmd.Flags().GetBool("check"); check {
+		param = append(param, "--check")
+	}
+
+	if diff, _ := cmd.Flags().GetBool("diff"); diff {
+		param = append(param, "--diff")
+	}
+
+	if verbose, _ := cmd.Flags().GetCount("verbose"); verbose > 0 {
+		param = append(param, "-"+strings.Repeat("v", verbose))
+	}
+
+	return param
+}
+
+func runPlaybook(cmd *cobra.Command, env []string, playbook string) error {
+	param := append(ansibleParams(cmd), playbook)
+
+	return executeExternalProgramEnv("ansible-playbook", env, param...)
+}
+
+func resolvePlaybook(name string) (string, error) {
+	if fileExists(name) {
+		return name, nil
+	}
+
+	if !strings.HasSuffix(name, ".yml") {
+		name = name + ".yml"
+	}
+
+	playbook := makePath("playbooks", name)
+
+	if !fileExists(playbook) {
+		return "", fmt.Errorf("can't find the '%s' playbook", playbook)
+	}
+
+	return playbook, nil
+}
+
+func completePlaybooks(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	ensureRootDirectory()
+
+	var names []string
+
+	files, _ := filepath.Glob(makePath("playbooks", "*.yml"))
+
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".yml")
+
+		if strings.HasPrefix(name, toComplete) {
+			names = append(names, name)
+		}
+	}
+
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,398 @@
+/*
+Copyright © 2024 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Labels applied to every object applied from a directory so objects that are
+// removed from it can be pruned.
+const (
+	projectLabel = "k8s-dev/project"
+	applyLabel   = "k8s-dev/apply"
+)
+
+// applyPathAnnotation records the directory an object was applied from, as
+// the apply label only holds a hash of it.
+const applyPathAnnotation = "k8s-dev/apply-path"
+
+// Kinds that must be deleted after the objects that depend on them.
+var lastDeletedKinds = []string{"CustomResourceDefinition", "Namespace"}
+
+var rolloutKinds = []string{"DaemonSet", "Deployment", "StatefulSet"}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply <dir>",
+	Short: "Apply a kustomization or a directory of manifests to the development environment",
+	Long: `Build a kustomization, or read a directory of plain manifests, and apply the objects to the
+Kubernetes development environment with server-side apply. Every object is labelled with the project
+and directory, so objects that were previously applied from the directory but no longer exist in it
+are pruned. The command waits for the rollouts of deployments, daemon sets and stateful sets.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		objects, selector, err := labelledManifests(cmd, args[0])
+		cobra.CheckErr(err)
+
+		dir, err := os.MkdirTemp("", "k8s-dev-apply-")
+		cobra.CheckErr(err)
+
+		file := makePath(dir, "manifests.yaml")
+
+		if err := writeManifests(file, objects); err != nil {
+			cobra.CheckErr(errors.Join(err, os.RemoveAll(dir)))
+		}
+
+		params := []string{"apply", "--server-side", "--field-manager=k8s-dev", "--filename", file}
+
+		if namespace, _ := cmd.Flags().GetString("namespace"); len(namespace) > 0 {
+			params = append(params, "--namespace", namespace)
+		}
+
+		if force, _ := cmd.Flags().GetBool("force-conflicts"); force {
+			params = append(params, "--force-conflicts")
+		}
+
+		printMessage(fmt.Sprintf("Applying %d object(s) from '%s'...", len(objects), args[0]))
+
+		var result map[string]interface{}
+
+		err = kubectlJSON(&result, params...)
+
+		cobra.CheckErr(os.RemoveAll(dir))
+		cobra.CheckErr(err)
+
+		applied := []map[string]interface{}{result}
+
+		if result["kind"] == "List" {
+			applied = nil
+
+			items, _ := result["items"].([]interface{})
+
+			for _, item := range items {
+				if object, ok := item.(map[string]interface{}); ok {
+					applied = append(applied, object)
+				}
+			}
+		}
+
+		var keys []string
+
+		for _, object := range applied {
+			key := snapshotKey(object)
+			keys = append(keys, key)
+
+			printSubMessage(fmt.Sprintf("applied %s", key))
+		}
+
+		if prune, _ := cmd.Flags().GetBool("prune"); prune {
+			existing, err := labelledObjects(selector)
+			cobra.CheckErr(err)
+
+			var stale []map[string]interface{}
+
+			for _, object := range existing {
+				if !slices.Contains(keys, snapshotKey(object)) {
+					stale = append(stale, object)
+				}
+			}
+
+			cobra.CheckErr(deleteObjects(stale, "pruned"))
+		}
+
+		if wait, _ := cmd.Flags().GetBool("wait"); wait {
+			timeout, _ := cmd.Flags().GetString("timeout")
+
+			for _, object := range applied {
+				kind, _ := object["kind"].(string)
+
+				if !slices.Contains(rolloutKinds, kind) {
+					continue
+				}
+
+				name := strings.ToLower(kind) + "/" + objectField(object, "metadata", "name")
+
+				printSubMessage(fmt.Sprintf("waiting for the rollout of %s", name))
+
+				_, err := kubectl("rollout", "status", name,
+					"--namespace", objectField(object, "metadata", "namespace"),
+					"--timeout", timeout)
+
+				cobra.CheckErr(err)
+			}
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		ensureRootDirectory()
+		cobra.CheckErr(ensureEnvironment())
+	},
+}
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete <dir>",
+	Short: "Delete the objects applied from a kustomization or a directory of manifests",
+	Long: `Delete the objects applied from a kustomization or a directory of manifests, including objects
+that have since been removed from the directory.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, selector, err := applyLabels(args[0])
+		cobra.CheckErr(err)
+
+		objects, err := labelledObjects(selector)
+		cobra.CheckErr(err)
+
+		if len(objects) == 0 {
+			printSubMessage(fmt.Sprintf("no objects applied from '%s' were found", args[0]))
+
+			return
+		}
+
+		printMessage(fmt.Sprintf("Deleting %d object(s) applied from '%s'...", len(objects), args[0]))
+
+		cobra.CheckErr(deleteObjects(objects, "deleted"))
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		ensureRootDirectory()
+		cobra.CheckErr(ensureEnvironment())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(deleteCmd)
+
+	applyCmd.Flags().StringP("namespace", "n", "", "namespace of objects that don't declare one")
+	applyCmd.Flags().Bool("prune", true, "delete objects previously applied from the directory that no longer exist in it")
+	applyCmd.Flags().Bool("wait", true, "wait for the rollouts of deployments, daemon sets and stateful sets")
+	applyCmd.Flags().String("timeout", "5m", "how long to wait for each rollout")
+	applyCmd.Flags().Bool("force-conflicts", false, "take ownership of fields managed by other field managers")
+}
+
+// labelledManifests returns the objects of a kustomization or directory of
+// manifests with the project labels applied and the selector matching them.
+func labelledManifests(cmd *cobra.Command, dir string) ([]map[string]interface{}, string, error) {
+	if isChart(dir) {
+		return nil, "", fmt.Errorf("'%s' is a Helm chart, use 'k8s-dev chart install' instead", dir)
+	}
+
+	if !dirExists(dir) {
+		return nil, "", fmt.Errorf("can't find the '%s' directory", dir)
+	}
+
+	labels, selector, err := applyLabels(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	path, err := applyPath(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rendered, err := renderManifests(cmd, dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var objects []map[string]interface{}
+
+	for _, r := range rendered {
+		metadata, ok := r.Object["metadata"].(map[string]interface{})
+
+		if !ok {
+			metadata = map[string]interface{}{}
+			r.Object["metadata"] = metadata
+		}
+
+		existing, ok := metadata["labels"].(map[string]interface{})
+
+		if !ok {
+			existing = map[string]interface{}{}
+			metadata["labels"] = existing
+		}
+
+		for k, v := range labels {
+			existing[k] = v
+		}
+
+		annotations, ok := metadata["annotations"].(map[string]interface{})
+
+		if !ok {
+			annotations = map[string]interface{}{}
+			metadata["annotations"] = annotations
+		}
+
+		annotations[applyPathAnnotation] = path
+
+		objects = append(objects, r.Object)
+	}
+
+	if len(objects) == 0 {
+		return nil, "", fmt.Errorf("'%s' doesn't contain any objects", dir)
+	}
+
+	return objects, selector, nil
+}
+
+// applyLabels returns the labels of the objects applied from dir and the
+// selector matching them.
+func applyLabels(dir string) (map[string]string, string, error) {
+	current, err := os.Getwd()
+	if err != nil {
+		return nil, "", err
+	}
+
+	path, err := applyPath(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Paths are hashed so different directories never share a label value.
+	labels := map[string]string{
+		projectLabel: projectID(current),
+		applyLabel:   fmt.Sprintf("%x", sha256.Sum256([]byte(path)))[:16],
+	}
+
+	selector := fmt.Sprintf("%s=%s,%s=%s", projectLabel, labels[projectLabel], applyLabel, labels[applyLabel])
+
+	return labels, selector, nil
+}
+
+// applyPath returns the cleaned path of dir relative to the project.
+func applyPath(dir string) (string, error) {
+	current, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	relative, err := filepath.Rel(current, abs)
+	if err != nil {
+		relative = abs
+	}
+
+	return filepath.ToSlash(filepath.Clean(relative)), nil
+}
+
+func labelledObjects(selector string) ([]map[string]interface{}, error) {
+	resources, err := listableResources()
+	if err != nil {
+		return nil, err
+	}
+
+	return listObjects(resources, "--selector", selector)
+}
+
+func deleteObjects(objects []map[string]interface{}, verb string) error {
+	last := func(object map[string]interface{}) bool {
+		kind, _ := object["kind"].(string)
+
+		return slices.Contains(lastDeletedKinds, kind)
+	}
+
+	sort.SliceStable(objects, func(i, j int) bool {
+		return !last(objects[i]) && last(objects[j])
+	})
+
+	for _, object := range objects {
+		apiVersion, _ := object["apiVersion"].(string)
+		kind, _ := object["kind"].(string)
+
+		params := []string{"delete", kubectlResource(apiVersion, kind), objectField(object, "metadata", "name"), "--ignore-not-found"}
+
+		if namespace := objectField(object, "metadata", "namespace"); len(namespace) > 0 {
+			params = append(params, "--namespace", namespace)
+		}
+
+		if _, err := kubectl(params...); err != nil {
+			return err
+		}
+
+		printSubMessage(fmt.Sprintf("%s %s", verb, snapshotKey(object)))
+	}
+
+	return nil
+}
+
+func writeManifests(path string, objects []map[string]interface{}) error {
+	var buf bytes.Buffer
+
+	for _, object := range objects {
+		content, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+
+		buf.WriteString("---\n")
+		buf.Write(content)
+	}
+
+	if err := ensureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// projectID returns a label value identifying the project in dir, made unique
+// by a hash of its absolute path so projects in folders with the same name
+// don't prune each other's objects.
+func projectID(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	name := labelValue(filepath.Base(dir))
+
+	if len(name) > 50 {
+		name = strings.Trim(name[:50], "_.-")
+	}
+
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(dir)))[:12]
+
+	if len(name) == 0 {
+		return hash
+	}
+
+	return name + "-" + hash
+}
+
+// labelValue converts value into a valid label value.
+func labelValue(value string) string {
+	value = regexp.MustCompile(`[^A-Za-z0-9_.-]+`).ReplaceAllString(filepath.ToSlash(value), ".")
+
+	if len(value) > 63 {
+		value = value[len(value)-63:]
+	}
+
+	return strings.Trim(value, "_.-")
+}
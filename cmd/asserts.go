@@ -0,0 +1,400 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// An assertion is a partial Kubernetes object that must exist in the cluster,
+// or, when declared in a file ending with "errors.yaml", must not exist. A
+// file may contain a "TestAssert" document whose timeout, in seconds, applies
+// to every object in that file.
+type assertion struct {
+	File    string
+	Object  map[string]interface{}
+	Absent  bool
+	Timeout time.Duration
+}
+
+type assertionResult struct {
+	Assertion assertion
+	Passed    bool
+	Message   string
+	Diff      []string
+}
+
+func (a assertion) Name() string {
+	kind, _ := a.Object["kind"].(string)
+	name := objectField(a.Object, "metadata", "name")
+
+	if len(name) == 0 {
+		name = "*"
+	}
+
+	return fmt.Sprintf("%s/%s", kind, name)
+}
+
+func loadAssertions(dir string, timeout time.Duration) ([]assertion, error) {
+	var assertions []assertion
+
+	var files []string
+
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(makePath(dir, pattern))
+		if err != nil {
+			return assertions, err
+		}
+
+		files = append(files, matches...)
+	}
+
+	sort.Strings(files)
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return assertions, err
+		}
+
+		var objects []map[string]interface{}
+
+		fileTimeout := timeout
+		decoder := yaml.NewDecoder(f)
+
+		for {
+			var object map[string]interface{}
+
+			err := decoder.Decode(&object)
+
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			if err != nil {
+				f.Close()
+
+				return assertions, fmt.Errorf("'%s' is not valid: %s", file, err)
+			}
+
+			if object == nil {
+				continue
+			}
+
+			if object["kind"] == "TestAssert" {
+				if seconds, ok := object["timeout"].(int); ok {
+					fileTimeout = time.Duration(seconds) * time.Second
+				}
+
+				continue
+			}
+
+			objects = append(objects, object)
+		}
+
+		f.Close()
+
+		for _, object := range objects {
+			assertions = append(assertions, assertion{
+				File:    file,
+				Object:  object,
+				Absent:  strings.HasSuffix(strings.TrimSuffix(strings.TrimSuffix(file, ".yaml"), ".yml"), "errors"),
+				Timeout: fileTimeout,
+			})
+		}
+	}
+
+	return assertions, nil
+}
+
+// evaluateAssertions checks each assertion against the cluster, retrying a
+// failing assertion until its timeout has elapsed.
+func evaluateAssertions(assertions []assertion, namespace string, w io.Writer) []assertionResult {
+	var results []assertionResult
+
+	for _, a := range assertions {
+		deadline := time.Now().Add(a.Timeout)
+
+		for {
+			result := evaluateAssertion(a, namespace)
+
+			if result.Passed || time.Now().After(deadline) {
+				results = append(results, result)
+				printAssertionResult(w, result)
+
+				break
+			}
+
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	return results
+}
+
+func evaluateAssertion(a assertion, namespace string) assertionResult {
+	result := assertionResult{Assertion: a}
+
+	candidates, err := assertionCandidates(a, namespace)
+	if err != nil {
+		result.Message = err.Error()
+
+		return result
+	}
+
+	var closest []string
+
+	for _, candidate := range candidates {
+		diff := compareFields(a.Object, candidate, "")
+
+		if len(diff) == 0 {
+			if a.Absent {
+				result.Message = "object exists but must not"
+			} else {
+				result.Passed = true
+			}
+
+			return result
+		}
+
+		if closest == nil || len(diff) < len(closest) {
+			closest = diff
+		}
+	}
+
+	if a.Absent {
+		result.Passed = true
+
+		return result
+	}
+
+	if len(candidates) == 0 {
+		result.Message = "object not found"
+	} else {
+		result.Message = "object does not match"
+		result.Diff = closest
+	}
+
+	return result
+}
+
+func assertionCandidates(a assertion, namespace string) ([]map[string]interface{}, error) {
+	var candidates []map[string]interface{}
+
+	apiVersion, _ := a.Object["apiVersion"].(string)
+	kind, _ := a.Object["kind"].(string)
+
+	if len(apiVersion) == 0 || len(kind) == 0 {
+		return candidates, fmt.Errorf("apiVersion and kind are required")
+	}
+
+	if ns := objectField(a.Object, "metadata", "namespace"); len(ns) > 0 {
+		namespace = ns
+	}
+
+	param := []string{"get", kubectlResource(apiVersion, kind), "--namespace", namespace}
+
+	if name := objectField(a.Object, "metadata", "name"); len(name) > 0 {
+		output, err := kubectl(append(param, name, "--ignore-not-found", "--output=json")...)
+		if err != nil {
+			return candidates, err
+		}
+
+		if len(strings.TrimSpace(output)) == 0 {
+			return candidates, nil
+		}
+
+		var object map[string]interface{}
+
+		if err := json.Unmarshal([]byte(output), &object); err != nil {
+			return candidates, err
+		}
+
+		return append(candidates, object), nil
+	}
+
+	if labels, ok := objectValue(a.Object, "metadata", "labels").(map[string]interface{}); ok {
+		var selector []string
+
+		for k, v := range labels {
+			selector = append(selector, fmt.Sprintf("%s=%v", k, v))
+		}
+
+		sort.Strings(selector)
+
+		param = append(param, "--selector", strings.Join(selector, ","))
+	}
+
+	var list struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+
+	if err := kubectlJSON(&list, param...); err != nil {
+		return candidates, err
+	}
+
+	return list.Items, nil
+}
+
+// compareFields returns a line for every field of expected that is missing
+// from, or differs in, actual. Lists must have the same length and are
+// compared element by element.
+func compareFields(expected, actual interface{}, path string) []string {
+	var diff []string
+
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return append(diff, fieldDiff(path, e, actual))
+		}
+
+		keys := make([]string, 0, len(e))
+
+		for k := range e {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			field := k
+
+			if len(path) > 0 {
+				field = path + "." + k
+			}
+
+			value, found := a[k]
+
+			if !found {
+				diff = append(diff, fieldDiff(field, e[k], nil))
+				continue
+			}
+
+			diff = append(diff, compareFields(e[k], value, field)...)
+		}
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok || len(a) != len(e) {
+			return append(diff, fieldDiff(path, e, actual))
+		}
+
+		for i := range e {
+			diff = append(diff, compareFields(e[i], a[i], fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	default:
+		if scalarString(expected) != scalarString(actual) {
+			diff = append(diff, fieldDiff(path, expected, actual))
+		}
+	}
+
+	return diff
+}
+
+// scalarString formats a scalar so values decoded from YAML and JSON compare
+// equal, since JSON decodes every number as a float64.
+func scalarString(v interface{}) string {
+	switch n := v.(type) {
+	case int:
+		return strconv.FormatFloat(float64(n), 'f', -1, 64)
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(v)
+}
+
+func fieldDiff(path string, expected, actual interface{}) string {
+	value := func(v interface{}) string {
+		if v == nil {
+			return "<missing>"
+		}
+
+		content, err := yaml.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+
+		return strings.ReplaceAll(strings.TrimSpace(string(content)), "\n", " ")
+	}
+
+	return fmt.Sprintf("%s\n- %s\n+ %s", path, value(expected), value(actual))
+}
+
+func objectValue(object map[string]interface{}, fields ...string) interface{} {
+	var value interface{} = object
+
+	for _, field := range fields {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		value = m[field]
+	}
+
+	return value
+}
+
+func objectField(object map[string]interface{}, fields ...string) string {
+	if s, ok := objectValue(object, fields...).(string); ok {
+		return s
+	}
+
+	return ""
+}
+
+func printAssertionResult(w io.Writer, result assertionResult) {
+	name := result.Assertion.Name()
+
+	if result.Assertion.Absent {
+		name = name + " (absent)"
+	}
+
+	source := filepath.Base(result.Assertion.File)
+
+	if result.Passed {
+		fmt.Fprintf(w, "  %s %s [%s]\n", Green("PASS"), name, source)
+
+		return
+	}
+
+	fmt.Fprintf(w, "  %s %s [%s]: %s\n", Red("FAIL"), name, source, result.Message)
+
+	for _, d := range result.Diff {
+		for i, line := range strings.Split(d, "\n") {
+			switch {
+			case i == 0:
+				fmt.Fprintf(w, "      %s\n", line)
+			case strings.HasPrefix(line, "-"):
+				fmt.Fprintf(w, "        %s\n", Red(line))
+			default:
+				fmt.Fprintf(w, "        %s\n", Green(line))
+			}
+		}
+	}
+}
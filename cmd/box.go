@@ -0,0 +1,336 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hashicorp/go-version"
+	"github.com/spf13/cobra"
+)
+
+type boxReference struct {
+	Project string
+	Name    string
+	Version string
+}
+
+type vagrantBox struct {
+	Name     string
+	Provider string
+	Version  string
+}
+
+var boxCmd = &cobra.Command{
+	Use:   "box",
+	Short: "Manage the Vagrant boxes used by Kubernetes development environments",
+	Long: `Manage the Vagrant boxes used by Kubernetes development environments. Only boxes referenced
+by k8s-dev projects on this machine, either in the project settings or the Vagrantfile, are affected.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		ensureRootDirectory()
+	},
+}
+
+var boxListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the Vagrant boxes referenced by k8s-dev projects",
+	Long:  "List the Vagrant boxes referenced by k8s-dev projects",
+	Run: func(cmd *cobra.Command, args []string) {
+		references, err := boxReferences()
+		cobra.CheckErr(err)
+
+		installed, err := installedBoxes()
+		cobra.CheckErr(err)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintln(w, "BOX\tPINNED\tINSTALLED\tPROJECT")
+
+		for _, r := range references {
+			pinned := r.Version
+
+			if len(pinned) == 0 {
+				pinned = "-"
+			}
+
+			versions := installedVersions(installed, r.Name)
+			available := "-"
+
+			if len(versions) > 0 {
+				available = strings.Join(versions, ", ")
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Name, pinned, available, r.Project)
+		}
+
+		cobra.CheckErr(w.Flush())
+
+		warnBoxPins(references, installed)
+	},
+}
+
+var boxOutdatedCmd = &cobra.Command{
+	Use:   "outdated",
+	Short: "Check whether the Vagrant boxes referenced by k8s-dev projects are outdated",
+	Long:  "Check whether the Vagrant boxes referenced by k8s-dev projects are outdated",
+	Run: func(cmd *cobra.Command, args []string) {
+		references, err := boxReferences()
+		cobra.CheckErr(err)
+
+		installed, err := installedBoxes()
+		cobra.CheckErr(err)
+
+		output, err := executeCommand("vagrant", "box", "outdated", "--global")
+		cobra.CheckErr(err)
+
+		names := referencedBoxNames(references)
+
+		for _, line := range strings.Split(output, "\n") {
+			for _, name := range names {
+				if strings.Contains(line, fmt.Sprintf("'%s'", name)) {
+					fmt.Println(strings.TrimSpace(line))
+				}
+			}
+		}
+
+		warnBoxPins(references, installed)
+	},
+}
+
+var boxUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update the Vagrant boxes referenced by k8s-dev projects",
+	Long:  "Update the Vagrant boxes referenced by k8s-dev projects",
+	Run: func(cmd *cobra.Command, args []string) {
+		references, err := boxReferences()
+		cobra.CheckErr(err)
+
+		for _, name := range referencedBoxNames(references) {
+			printSubMessage(fmt.Sprintf("updating '%s'", name))
+			cobra.CheckErr(executeExternalProgram("vagrant", "box", "update", "--box", name))
+		}
+
+		installed, err := installedBoxes()
+		cobra.CheckErr(err)
+
+		warnBoxPins(references, installed)
+	},
+}
+
+var boxPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old versions of the Vagrant boxes referenced by k8s-dev projects",
+	Long: `Remove old versions of the Vagrant boxes referenced by k8s-dev projects. Boxes pinned by a
+project to a version other than the newest installed one, and boxes used by Vagrant machines, are kept
+unless --include-used is provided.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		force, _ := cmd.Flags().GetBool("force")
+		includeUsed, _ := cmd.Flags().GetBool("include-used")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		references, err := boxReferences()
+		cobra.CheckErr(err)
+
+		installed, err := installedBoxes()
+		cobra.CheckErr(err)
+
+		for _, name := range referencedBoxNames(references) {
+			latest := latestVersion(installedVersions(installed, name))
+			skip := false
+
+			for _, r := range references {
+				if r.Name == name && len(r.Version) > 0 && r.Version != latest {
+					fmt.Println(Warn(fmt.Sprintf("'%s' pins '%s' version %s which would be pruned", r.Project, name, r.Version)))
+					skip = true
+				}
+			}
+
+			if skip && !includeUsed {
+				printSubMessage(fmt.Sprintf("skipping '%s'", name))
+				continue
+			}
+
+			param := []string{"box", "prune", "--name", name}
+
+			if !includeUsed {
+				param = append(param, "--keep-active-boxes")
+			}
+
+			if dryRun {
+				param = append(param, "--dry-run")
+			}
+
+			if force {
+				param = append(param, "--force")
+			}
+
+			printSubMessage(fmt.Sprintf("pruning '%s'", name))
+			cobra.CheckErr(executeExternalProgram("vagrant", param...))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(boxCmd)
+
+	boxCmd.AddCommand(boxListCmd)
+	boxCmd.AddCommand(boxOutdatedCmd)
+	boxCmd.AddCommand(boxUpdateCmd)
+	boxCmd.AddCommand(boxPruneCmd)
+
+	boxPruneCmd.Flags().BoolP("force", "f", false, "remove boxes without confirmation")
+	boxPruneCmd.Flags().Bool("include-used", false, "also remove boxes pinned by projects or used by Vagrant machines")
+	boxPruneCmd.Flags().BoolP("dry-run", "n", false, "only print the boxes that would be removed")
+}
+
+func boxReferences() ([]boxReference, error) {
+	var references []boxReference
+
+	projects, err := knownProjects()
+	if err != nil {
+		return references, err
+	}
+
+	for _, project := range projects {
+		settings, err := readSettings(project)
+		if err != nil {
+			return references, err
+		}
+
+		name := settings.Box
+		pinned := settings.BoxVersion
+
+		if len(name) == 0 {
+			name, pinned, err = vagrantfileBox(project)
+			if err != nil {
+				return references, err
+			}
+		}
+
+		if len(name) > 0 {
+			references = append(references, boxReference{
+				Project: project,
+				Name:    name,
+				Version: pinned,
+			})
+		}
+	}
+
+	if len(references) == 0 {
+		return references, errors.New("no vagrant boxes are referenced by k8s-dev projects on this machine")
+	}
+
+	return references, nil
+}
+
+func installedBoxes() ([]vagrantBox, error) {
+	var boxes []vagrantBox
+
+	output, err := executeCommand("vagrant", "box", "list")
+	if err != nil {
+		return boxes, fmt.Errorf("%s\n%s", output, err)
+	}
+
+	// debian/bookworm64 (virtualbox, 12.20240503.1)
+	// debian/bookworm64 (virtualbox, 12.20240503.1, (amd64))
+	pattern := regexp.MustCompile(`^(\S+)\s+\(([^,]+),\s*([^,)]+)`)
+
+	for _, line := range strings.Split(output, "\n") {
+		if m := pattern.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			boxes = append(boxes, vagrantBox{
+				Name:     m[1],
+				Provider: m[2],
+				Version:  m[3],
+			})
+		}
+	}
+
+	return boxes, nil
+}
+
+func installedVersions(boxes []vagrantBox, name string) []string {
+	var versions []string
+
+	for _, b := range boxes {
+		if b.Name == name && !slices.Contains(versions, b.Version) {
+			versions = append(versions, b.Version)
+		}
+	}
+
+	return versions
+}
+
+func latestVersion(versions []string) string {
+	latest := ""
+
+	for _, v := range versions {
+		if len(latest) == 0 || compareVersions(v, latest) > 0 {
+			latest = v
+		}
+	}
+
+	return latest
+}
+
+func compareVersions(a, b string) int {
+	va, errA := version.NewVersion(a)
+	vb, errB := version.NewVersion(b)
+
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+
+	return va.Compare(vb)
+}
+
+func referencedBoxNames(references []boxReference) []string {
+	var names []string
+
+	for _, r := range references {
+		if !slices.Contains(names, r.Name) {
+			names = append(names, r.Name)
+		}
+	}
+
+	return names
+}
+
+func warnBoxPins(references []boxReference, installed []vagrantBox) {
+	for _, r := range references {
+		if len(r.Version) == 0 {
+			continue
+		}
+
+		versions := installedVersions(installed, r.Name)
+
+		if !slices.Contains(versions, r.Version) {
+			fmt.Println(Warn(fmt.Sprintf("'%s' pins '%s' version %s which is not installed", r.Project, r.Name, r.Version)))
+			continue
+		}
+
+		latest := latestVersion(versions)
+
+		if compareVersions(latest, r.Version) > 0 {
+			fmt.Println(Warn(fmt.Sprintf("'%s' pins '%s' version %s which is outdated (latest installed: %s)", r.Project, r.Name, r.Version, latest)))
+		}
+	}
+}
@@ -0,0 +1,434 @@
+/*
+Copyright © 2024 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// chartOverlays are applied, in order, when found in the chart directory
+// before any values given with --values.
+var chartOverlays = []string{"values-dev.yaml", "values-dev.yml"}
+
+type chartInfo struct {
+	Name         string            `yaml:"name"`
+	Version      string            `yaml:"version"`
+	Dependencies []chartDependency `yaml:"dependencies"`
+}
+
+type chartDependency struct {
+	Name       string `yaml:"name"`
+	Repository string `yaml:"repository"`
+}
+
+var chartCmd = &cobra.Command{
+	Use:   "chart",
+	Short: "Develop a local Helm chart against the Kubernetes development environment",
+	Long: `Develop a local Helm chart against the Kubernetes development environment. The release name
+and namespace are derived from the chart name unless given. The values-dev.yaml overlay in the chart
+directory is applied automatically, followed by any values files given with --values.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		ensureRootDirectory()
+	},
+}
+
+var chartLintCmd = &cobra.Command{
+	Use:   "lint <path>",
+	Short: "Lint a local Helm chart with the development values",
+	Long:  "Lint a local Helm chart with the development values",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		chart, err := readChart(args[0])
+		cobra.CheckErr(err)
+		cobra.CheckErr(chartDependencies(args[0], chart))
+
+		params := append([]string{"lint", args[0], "--strict"}, chartValues(cmd, args[0])...)
+
+		cobra.CheckErr(executeExternalProgram("helm", params...))
+	},
+}
+
+var chartTemplateCmd = &cobra.Command{
+	Use:   "template <path>",
+	Short: "Render the manifests of a local Helm chart with the development values",
+	Long:  "Render the manifests of a local Helm chart with the development values",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		chart, err := readChart(args[0])
+		cobra.CheckErr(err)
+		cobra.CheckErr(chartDependencies(args[0], chart))
+
+		release, namespace := chartRelease(cmd, chart)
+
+		params := append([]string{"template", release, args[0], "--namespace", namespace}, chartValues(cmd, args[0])...)
+
+		cobra.CheckErr(executeExternalProgram("helm", params...))
+	},
+}
+
+var chartInstallCmd = &cobra.Command{
+	Use:   "install <path>",
+	Short: "Install a local Helm chart and wait for it to be ready",
+	Long:  "Install a local Helm chart and wait for it to be ready",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(chartDeploy(cmd, args[0], "install"))
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ensureEnvironment())
+	},
+}
+
+var chartUpgradeCmd = &cobra.Command{
+	Use:   "upgrade <path>",
+	Short: "Upgrade, or install, a local Helm chart and wait for it to be ready",
+	Long:  "Upgrade, or install, a local Helm chart and wait for it to be ready",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(chartDeploy(cmd, args[0], "upgrade", "--install"))
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ensureEnvironment())
+	},
+}
+
+var chartUninstallCmd = &cobra.Command{
+	Use:   "uninstall <path>",
+	Short: "Uninstall the release of a local Helm chart",
+	Long:  "Uninstall the release of a local Helm chart",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		chart, err := readChart(args[0])
+		cobra.CheckErr(err)
+
+		release, namespace := chartRelease(cmd, chart)
+
+		printMessage(fmt.Sprintf("Uninstalling '%s' from '%s'...", release, namespace))
+
+		cobra.CheckErr(executeExternalProgram("helm", helmParams("uninstall", release, "--namespace", namespace, "--wait")...))
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ensureEnvironment())
+	},
+}
+
+var chartTestCmd = &cobra.Command{
+	Use:   "test <path>",
+	Short: "Run the tests of the release of a local Helm chart",
+	Long:  "Run the tests of the release of a local Helm chart",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		chart, err := readChart(args[0])
+		cobra.CheckErr(err)
+
+		release, namespace := chartRelease(cmd, chart)
+
+		cobra.CheckErr(chartTest(cmd, release, namespace))
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(ensureEnvironment())
+	},
+}
+
+var chartSnapshotCmd = &cobra.Command{
+	Use:   "snapshot <path>",
+	Short: "Compare the rendered manifests of a local Helm chart with golden files",
+	Long: `Render a local Helm chart once for each values file in its ci or tests/values directory, or once
+with the default values when there are none, and compare the normalized manifests with the golden files
+in tests/__snapshot__, named after the directory and file of the values, such as ci-default.yaml.
+A missing golden file fails the comparison. Use --update to create missing golden files and accept changes.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		chart, err := readChart(args[0])
+		cobra.CheckErr(err)
+		cobra.CheckErr(chartDependencies(args[0], chart))
+
+		release, namespace := chartRelease(cmd, chart)
+		update, _ := cmd.Flags().GetBool("update")
+
+		values := chartSnapshotValues(args[0])
+		dir := makePath(args[0], "tests", "__snapshot__")
+
+		if update {
+			cobra.CheckErr(ensureDir(dir))
+		}
+
+		changed := 0
+		missing := 0
+
+		names := make([]string, 0, len(values))
+
+		for name := range values {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			file := values[name]
+			params := []string{"template", release, args[0], "--namespace", namespace}
+
+			if len(file) > 0 {
+				params = append(params, "--values", file)
+			}
+
+			output, err := executeCommandOutput("helm", params...)
+			cobra.CheckErr(err)
+
+			current, err := normalizeManifests(output)
+			cobra.CheckErr(err)
+
+			golden := makePath(dir, name+".yaml")
+
+			if !fileExists(golden) && !update {
+				missing++
+
+				fmt.Printf("  %s %s\n", Red("MISSING"), name)
+
+				continue
+			}
+
+			if update {
+				printSubMessage(fmt.Sprintf("writing snapshot '%s'", golden))
+				cobra.CheckErr(os.WriteFile(golden, []byte(joinManifests(current)), 0644))
+
+				continue
+			}
+
+			content, err := readFile(golden)
+			cobra.CheckErr(err)
+
+			previous, err := normalizeManifests(content)
+			cobra.CheckErr(err)
+
+			lines := diffManifests(previous, current)
+
+			if len(lines) == 0 {
+				fmt.Printf("  %s %s\n", Green("PASS"), name)
+
+				continue
+			}
+
+			changed++
+
+			fmt.Printf("  %s %s\n", Red("FAIL"), name)
+
+			for _, line := range lines {
+				fmt.Println(line)
+			}
+		}
+
+		snapshots, _ := filepath.Glob(makePath(dir, "*.yaml"))
+
+		for _, s := range snapshots {
+			name := strings.TrimSuffix(filepath.Base(s), ".yaml")
+
+			if _, ok := values[name]; ok {
+				continue
+			}
+
+			if update {
+				printSubMessage(fmt.Sprintf("removing obsolete snapshot '%s'", s))
+				cobra.CheckErr(removeFile(s))
+			} else {
+				printSubMessage(fmt.Sprintf("snapshot '%s' has no values file, --update removes it", s))
+			}
+		}
+
+		if changed > 0 || missing > 0 {
+			cobra.CheckErr(fmt.Errorf("%d of %d snapshot(s) changed and %d missing, run with --update to accept the changes", changed, len(values), missing))
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		// Snapshots must render the same from the chart alone.
+		if cmd.Flags().Changed("values") || cmd.Flags().Changed("set") {
+			cobra.CheckErr(errors.New("snapshots are rendered from the values files in ci and tests/values, --values and --set can't be used"))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(chartCmd)
+
+	chartCmd.AddCommand(chartLintCmd)
+	chartCmd.AddCommand(chartTemplateCmd)
+	chartCmd.AddCommand(chartInstallCmd)
+	chartCmd.AddCommand(chartUpgradeCmd)
+	chartCmd.AddCommand(chartUninstallCmd)
+	chartCmd.AddCommand(chartTestCmd)
+	chartCmd.AddCommand(chartSnapshotCmd)
+
+	chartCmd.PersistentFlags().StringP("release", "r", "", "release name (default is the chart name)")
+	chartCmd.PersistentFlags().StringP("namespace", "n", "", "namespace of the release (default is the release name)")
+	chartCmd.PersistentFlags().StringArrayP("values", "f", []string{}, "values file applied after the development overlay (can be repeated)")
+	chartCmd.PersistentFlags().StringArray("set", []string{}, "set a value as key=value (can be repeated)")
+	chartCmd.PersistentFlags().String("timeout", "5m", "how long to wait for the release to be ready or the tests to finish")
+
+	chartSnapshotCmd.Flags().BoolP("update", "u", false, "accept the changes by overwriting the golden files")
+
+	for _, c := range []*cobra.Command{chartInstallCmd, chartUpgradeCmd} {
+		c.Flags().Bool("test", false, "run the tests of the release once it is ready")
+	}
+}
+
+func readChart(path string) (chartInfo, error) {
+	var chart chartInfo
+
+	content, err := os.ReadFile(makePath(path, "Chart.yaml"))
+	if err != nil {
+		return chart, fmt.Errorf("'%s' is not a Helm chart", path)
+	}
+
+	err = yaml.Unmarshal(content, &chart)
+
+	return chart, err
+}
+
+// chartRelease returns the release name and namespace given as flags or
+// derived from the chart name.
+func chartRelease(cmd *cobra.Command, chart chartInfo) (string, string) {
+	release, _ := cmd.Flags().GetString("release")
+	namespace, _ := cmd.Flags().GetString("namespace")
+
+	if len(release) == 0 {
+		release = strings.Trim(regexp.MustCompile(`[^a-z0-9-]+`).ReplaceAllString(strings.ToLower(chart.Name), "-"), "-")
+	}
+
+	if len(namespace) == 0 {
+		namespace = release
+	}
+
+	return release, namespace
+}
+
+func chartValues(cmd *cobra.Command, path string) []string {
+	var params []string
+
+	for _, overlay := range chartOverlays {
+		if fileExists(makePath(path, overlay)) {
+			params = append(params, "--values", makePath(path, overlay))
+		}
+	}
+
+	values, _ := cmd.Flags().GetStringArray("values")
+
+	for _, v := range values {
+		params = append(params, "--values", v)
+	}
+
+	set, _ := cmd.Flags().GetStringArray("set")
+
+	for _, s := range set {
+		params = append(params, "--set", s)
+	}
+
+	return params
+}
+
+// chartSnapshotValues returns the values files of the chart to render
+// snapshots for, by snapshot name. Names are prefixed with the directory of
+// the values file so files with the same name don't share a snapshot.
+func chartSnapshotValues(path string) map[string]string {
+	values := map[string]string{}
+
+	sources := map[string]string{
+		"ci":     makePath(path, "ci"),
+		"values": makePath(path, "tests", "values"),
+	}
+
+	for prefix, dir := range sources {
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			files, _ := filepath.Glob(makePath(dir, pattern))
+
+			for _, f := range files {
+				values[prefix+"-"+strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))] = f
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		values["default"] = ""
+	}
+
+	return values
+}
+
+// chartDependencies downloads the dependencies of a chart that hasn't had its
+// dependencies built yet.
+func chartDependencies(path string, chart chartInfo) error {
+	if len(chart.Dependencies) == 0 {
+		return nil
+	}
+
+	if archives, _ := filepath.Glob(makePath(path, "charts", "*.tgz")); len(archives) > 0 {
+		return nil
+	}
+
+	printSubMessage("updating chart dependencies")
+
+	return executeExternalProgram("helm", "dependency", "update", path)
+}
+
+func chartDeploy(cmd *cobra.Command, path string, params ...string) error {
+	chart, err := readChart(path)
+	if err != nil {
+		return err
+	}
+
+	if err := chartDependencies(path, chart); err != nil {
+		return err
+	}
+
+	release, namespace := chartRelease(cmd, chart)
+	timeout, _ := cmd.Flags().GetString("timeout")
+
+	printMessage(fmt.Sprintf("Deploying '%s' %s to '%s'...", release, chart.Version, namespace))
+
+	params = append(params, release, path,
+		"--namespace", namespace,
+		"--create-namespace",
+		"--wait",
+		"--timeout", timeout)
+
+	params = append(params, chartValues(cmd, path)...)
+
+	if err := executeExternalProgram("helm", helmParams(params...)...); err != nil {
+		return err
+	}
+
+	if test, _ := cmd.Flags().GetBool("test"); test {
+		return chartTest(cmd, release, namespace)
+	}
+
+	return nil
+}
+
+func chartTest(cmd *cobra.Command, release, namespace string) error {
+	timeout, _ := cmd.Flags().GetString("timeout")
+
+	printMessage(fmt.Sprintf("Testing '%s' in '%s'...", release, namespace))
+
+	return executeExternalProgram("helm", helmParams("test", release, "--namespace", namespace, "--logs", "--timeout", timeout)...)
+}
@@ -0,0 +1,296 @@
+/*
+Copyright © 2024 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// chartRepoName is the name the local chart repository is added to helm as.
+const chartRepoName = "k8s-dev"
+
+var chartRepoCmd = &cobra.Command{
+	Use:   "chart-repo",
+	Short: "Package the charts of the project into a local chart repository",
+	Long: `Package the charts of the project into a local chart repository that is served over HTTP on the
+host-only interface so the nodes of the development environment can reach it. The repository is added
+to helm as 'k8s-dev', so charts can depend on each other without publishing them to a real repository.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		ensureRootDirectory()
+	},
+}
+
+var chartRepoServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Package every chart of the project and serve the local chart repository",
+	Long:  "Package every chart of the project and serve the local chart repository until interrupted",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, address, url := chartRepoLocation(cmd)
+
+		cobra.CheckErr(ensureDir(dir))
+
+		server, err := serveChartRepo(dir, address)
+		cobra.CheckErr(err)
+
+		printMessage(fmt.Sprintf("Serving '%s' at %s...", dir, url))
+
+		charts, err := discoverCharts()
+		cobra.CheckErr(err)
+
+		cobra.CheckErr(pushCharts(charts, dir, url))
+
+		printMessage("Press Ctrl+C to stop...")
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+
+		<-interrupt
+
+		fmt.Println()
+		cobra.CheckErr(server.Shutdown(context.Background()))
+	},
+}
+
+var chartRepoPushCmd = &cobra.Command{
+	Use:   "push [chart...]",
+	Short: "Package charts into the local chart repository",
+	Long: `Package charts, or every chart of the project, into the local chart repository. Unless the
+repository is already being served, it is served while pushing so dependencies on charts of the local
+repository can be resolved.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, address, url := chartRepoLocation(cmd)
+
+		charts := args
+
+		if len(charts) == 0 {
+			var err error
+
+			charts, err = discoverCharts()
+			cobra.CheckErr(err)
+		}
+
+		for _, c := range charts {
+			if !isChart(c) {
+				cobra.CheckErr(fmt.Errorf("'%s' is not a Helm chart", c))
+			}
+		}
+
+		cobra.CheckErr(ensureDir(dir))
+
+		server, err := serveChartRepo(dir, address)
+
+		if errors.Is(err, syscall.EADDRINUSE) {
+			printSubMessage(fmt.Sprintf("'%s' is in use, pushing to the repository being served", address))
+		} else {
+			cobra.CheckErr(err)
+		}
+
+		err = pushCharts(charts, dir, url)
+
+		if server != nil {
+			err = errors.Join(err, server.Shutdown(context.Background()))
+		}
+
+		cobra.CheckErr(err)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(chartRepoCmd)
+
+	chartRepoCmd.AddCommand(chartRepoServeCmd)
+	chartRepoCmd.AddCommand(chartRepoPushCmd)
+
+	chartRepoCmd.PersistentFlags().String("dir", ".chart-repo", "directory of the packaged charts and repository index")
+	chartRepoCmd.PersistentFlags().String("address", "", "address to serve the repository on (default is the host-only interface)")
+	chartRepoCmd.PersistentFlags().Int("port", 8879, "port to serve the repository on")
+}
+
+// chartRepoLocation returns the index directory, listen address and URL of
+// the local chart repository.
+func chartRepoLocation(cmd *cobra.Command) (string, string, string) {
+	dir, _ := cmd.Flags().GetString("dir")
+	address, _ := cmd.Flags().GetString("address")
+	port, _ := cmd.Flags().GetInt("port")
+
+	if len(address) == 0 {
+		address = hostAddress
+
+		if isMinikubeEnv() {
+			ip, err := GetHostIP()
+			cobra.CheckErr(err)
+
+			address = ip
+		}
+	}
+
+	address = net.JoinHostPort(address, strconv.Itoa(port))
+
+	return dir, address, "http://" + address
+}
+
+// serveChartRepo serves dir at address until the returned server is shut down.
+func serveChartRepo(dir, address string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: http.FileServer(http.Dir(dir))}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			cobra.CheckErr(err)
+		}
+	}()
+
+	return server, nil
+}
+
+// discoverCharts returns the charts of the project, ordered so charts come
+// after the project charts they depend on.
+func discoverCharts() ([]string, error) {
+	var charts []string
+
+	err := walkProject(func(path string, d fs.DirEntry) error {
+		if d.IsDir() && isChart(path) {
+			charts = append(charts, path)
+
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return charts, err
+	}
+
+	if len(charts) == 0 {
+		return charts, errors.New("no charts found in the project")
+	}
+
+	names := map[string]string{}
+	needs := map[string][]string{}
+
+	for _, c := range charts {
+		chart, err := readChart(c)
+		if err != nil {
+			return charts, err
+		}
+
+		names[chart.Name] = c
+
+		for _, d := range chart.Dependencies {
+			needs[c] = append(needs[c], d.Name)
+		}
+	}
+
+	var ordered []string
+
+	var visit func(c string, seen []string)
+
+	visit = func(c string, seen []string) {
+		if slices.Contains(ordered, c) || slices.Contains(seen, c) {
+			return
+		}
+
+		for _, n := range needs[c] {
+			if dependency, ok := names[n]; ok {
+				visit(dependency, append(seen, c))
+			}
+		}
+
+		ordered = append(ordered, c)
+	}
+
+	for _, c := range charts {
+		visit(c, nil)
+	}
+
+	return ordered, nil
+}
+
+// pushCharts packages each chart into dir and indexes the repository after
+// each one, so later charts can depend on the charts packaged before them.
+func pushCharts(charts []string, dir, url string) error {
+	if _, err := executeCommandOutput("helm", "repo", "index", dir, "--url", url); err != nil {
+		return err
+	}
+
+	if _, err := executeCommandOutput("helm", "repo", "add", chartRepoName, url, "--force-update"); err != nil {
+		return err
+	}
+
+	for _, c := range charts {
+		chart, err := readChart(c)
+		if err != nil {
+			return err
+		}
+
+		if usesChartRepo(chart, url) {
+			// The packaged dependencies may be stale copies of charts pushed
+			// since, so they are always updated.
+			printSubMessage(fmt.Sprintf("updating the dependencies of '%s'", chart.Name))
+
+			if _, err := executeCommandOutput("helm", "dependency", "update", c); err != nil {
+				return err
+			}
+		} else if err := chartDependencies(c, chart); err != nil {
+			return err
+		}
+
+		printSubMessage(fmt.Sprintf("packaging '%s' %s", chart.Name, chart.Version))
+
+		if _, err := executeCommandOutput("helm", "package", c, "--destination", dir); err != nil {
+			return err
+		}
+
+		if _, err := executeCommandOutput("helm", "repo", "index", dir, "--url", url); err != nil {
+			return err
+		}
+
+		if _, err := executeCommandOutput("helm", "repo", "update", chartRepoName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// usesChartRepo reports whether chart depends on charts from the local chart
+// repository.
+func usesChartRepo(chart chartInfo, url string) bool {
+	return slices.ContainsFunc(chart.Dependencies, func(d chartDependency) bool {
+		repository := strings.TrimSuffix(d.Repository, "/")
+
+		return repository == url || repository == "@"+chartRepoName || repository == "alias:"+chartRepoName
+	})
+}
@@ -0,0 +1,192 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Field managers of the Kubernetes modules of Ansible, which use the Python
+// client unless a field manager is given for server-side apply.
+var ansibleFieldManagers = []string{"ansible", "OpenAPI-Generator"}
+
+// cleanupTestRun deletes the namespaces of the tests and the cluster-scoped
+// objects that are not in before and can be traced to the test run. Tests may
+// run in parallel, so cluster-scoped objects can't be attributed to a single
+// test and are all kept when any test failed and keepOnFailure is set.
+func cleanupTestRun(tests []*roleTest, results []testResult, before clusterSnapshot, keepOnFailure bool) error {
+	var namespaces []string
+
+	for _, t := range tests {
+		if len(t.Namespace) > 0 {
+			namespaces = append(namespaces, t.Namespace)
+		}
+	}
+
+	// The objects of the test namespaces are gathered before the namespaces
+	// are deleted so cluster-scoped objects they own can be recognised.
+	owners, err := namespaceUIDs(namespaces)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+
+	for i, t := range tests {
+		if len(t.Namespace) == 0 {
+			continue
+		}
+
+		if keepOnFailure && !results[i].Passed() {
+			printSubMessage(fmt.Sprintf("keeping namespace '%s' of failed test '%s'", t.Namespace, t.Name()))
+			failed = true
+
+			continue
+		}
+
+		printSubMessage(fmt.Sprintf("deleting namespace '%s'", t.Namespace))
+
+		if _, err := kubectl("delete", "namespace", t.Namespace, "--ignore-not-found"); err != nil {
+			return err
+		}
+	}
+
+	resources, err := listableResources("--namespaced=false")
+	if err != nil {
+		return err
+	}
+
+	objects, err := listObjects(resources)
+	if err != nil {
+		return err
+	}
+
+	var created []map[string]interface{}
+
+	for _, object := range objects {
+		key := snapshotKey(object)
+
+		// Nodes join on their own and deleting one would break the cluster.
+		if _, found := before[key]; found || object["kind"] == "Node" {
+			continue
+		}
+
+		if !createdByTestRun(object, namespaces, owners) {
+			printSubMessage(fmt.Sprintf("leaving %s, it can't be traced to the test run", key))
+
+			continue
+		}
+
+		created = append(created, object)
+	}
+
+	if failed && len(created) > 0 {
+		printSubMessage(fmt.Sprintf("keeping %d cluster-scoped object(s) created by the test run", len(created)))
+
+		return nil
+	}
+
+	for _, object := range created {
+		apiVersion, _ := object["apiVersion"].(string)
+		kind, _ := object["kind"].(string)
+
+		printSubMessage(fmt.Sprintf("deleting %s", snapshotKey(object)))
+
+		_, err := kubectl("delete", kubectlResource(apiVersion, kind), objectField(object, "metadata", "name"), "--ignore-not-found")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// namespaceUIDs returns the UIDs of the namespaces and of every object in
+// them.
+func namespaceUIDs(namespaces []string) ([]string, error) {
+	var uids []string
+
+	if len(namespaces) == 0 {
+		return uids, nil
+	}
+
+	resources, err := listableResources("--namespaced=true")
+	if err != nil {
+		return uids, err
+	}
+
+	for _, ns := range namespaces {
+		var namespace map[string]interface{}
+
+		if err := kubectlJSON(&namespace, "get", "namespace", ns); err != nil {
+			// The namespace of a test that failed early may not exist.
+			continue
+		}
+
+		uids = append(uids, objectField(namespace, "metadata", "uid"))
+
+		objects, err := getObjects(resources, "--namespace", ns)
+		if err != nil {
+			return uids, err
+		}
+
+		for _, object := range objects {
+			uids = append(uids, objectField(object, "metadata", "uid"))
+		}
+	}
+
+	return uids, nil
+}
+
+// createdByTestRun reports whether a cluster-scoped object carries the label
+// of a test namespace, is owned by an object in a test namespace or was
+// written by the Kubernetes modules of Ansible.
+func createdByTestRun(object map[string]interface{}, namespaces, owners []string) bool {
+	metadata, _ := object["metadata"].(map[string]interface{})
+
+	if labels, ok := metadata["labels"].(map[string]interface{}); ok {
+		if value, ok := labels[testNamespaceLabel].(string); ok && slices.Contains(namespaces, value) {
+			return true
+		}
+	}
+
+	references, _ := metadata["ownerReferences"].([]interface{})
+
+	for _, r := range references {
+		reference, _ := r.(map[string]interface{})
+
+		if uid, ok := reference["uid"].(string); ok && slices.Contains(owners, uid) {
+			return true
+		}
+	}
+
+	fields, _ := metadata["managedFields"].([]interface{})
+
+	for _, f := range fields {
+		field, _ := f.(map[string]interface{})
+		manager, _ := field["manager"].(string)
+
+		if slices.ContainsFunc(ansibleFieldManagers, func(m string) bool {
+			return strings.HasPrefix(manager, m)
+		}) {
+			return true
+		}
+	}
+
+	return false
+}
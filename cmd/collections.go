@@ -0,0 +1,88 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type galaxyInfo struct {
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+	Version   string `yaml:"version"`
+}
+
+func isCollection(dir string) bool {
+	return fileExists(makePath(dir, "galaxy.yml"))
+}
+
+func readGalaxy(dir string) (galaxyInfo, error) {
+	var info galaxyInfo
+
+	file := makePath(dir, "galaxy.yml")
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return info, err
+	}
+
+	if err := yaml.Unmarshal(content, &info); err != nil {
+		return info, fmt.Errorf("'%s' is not valid: %s", file, err)
+	}
+
+	if len(info.Namespace) == 0 || len(info.Name) == 0 {
+		return info, fmt.Errorf("'%s' must define a namespace and name", file)
+	}
+
+	return info, nil
+}
+
+// isCollectionRole reports whether name is a fully qualified collection role
+// (namespace.collection.role) rather than a standalone role.
+func isCollectionRole(name string) bool {
+	return strings.Count(name, ".") == 2 && !isRolePath(name)
+}
+
+// collectionRoleDir returns where a fully qualified collection role is
+// installed below the project's collections path.
+func collectionRoleDir(name string) string {
+	parts := strings.Split(name, ".")
+
+	return makePath("collections", "ansible_collections", parts[0], parts[1], "roles", parts[2])
+}
+
+// installCollection installs the collection under development in dir into the
+// project's collections path so its content can be used by fully qualified
+// name.
+func installCollection(dir string) error {
+	if !isCollection(dir) {
+		return fmt.Errorf("'%s' is not a collection, galaxy.yml was not found", dir)
+	}
+
+	info, err := readGalaxy(dir)
+	if err != nil {
+		return err
+	}
+
+	printSubMessage(fmt.Sprintf("installing '%s.%s' from '%s'", info.Namespace, info.Name, dir))
+
+	return executeExternalProgram("ansible-galaxy", "collection", "install", dir,
+		"--collections-path", "./collections", "--force")
+}
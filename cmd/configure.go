@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/spf13/cobra"
 )
@@ -31,18 +32,15 @@ var configCmd = &cobra.Command{
 			"K8S_AUTH_VERIFY_SSL=false",
 		}
 
-		k8s, _ := cmd.Flags().GetBool("k8s")
+		stages, err := configureStages(cmd)
+		cobra.CheckErr(err)
 
-		if k8s {
-			c, _ := cmd.Flags().GetString("pre-configure")
+		for _, stage := range stages {
+			printSubMessage(fmt.Sprintf("running %s playbook '%s'", stage.Name, stage.Playbook))
 
-			if len(c) > 0 {
-				cobra.CheckErr(executeExternalProgramEnv("ansible-playbook", env, fmt.Sprintf("playbooks/%s.yml", c)))
+			if err := runPlaybook(cmd, env, stage.Playbook); err != nil {
+				cobra.CheckErr(fmt.Errorf("%s stage failed running '%s': %s", stage.Name, stage.Playbook, err))
 			}
-
-			cobra.CheckErr(executeExternalProgramEnv("ansible-playbook", env, "playbooks/init.yml"))
-		} else {
-			cobra.CheckErr(executeExternalProgramEnv("ansible-playbook", env, "playbooks/config.yml"))
 		}
 
 		pods, _ := cmd.Flags().GetBool("pods")
@@ -53,6 +51,10 @@ var configCmd = &cobra.Command{
 	},
 	PreRun: func(cmd *cobra.Command, args []string) {
 		ensureRootDirectory()
+		cobra.CheckErr(ensureConfigPlaybook())
+
+		_, err := configureStages(cmd)
+		cobra.CheckErr(err)
 
 		k8s, _ := cmd.Flags().GetBool("k8s")
 
@@ -69,7 +71,72 @@ var configCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(configCmd)
 
-	configCmd.Flags().StringP("pre-config", "c", "", "Include a pre-configuration playbook")
+	configCmd.Flags().StringArray("pre", []string{}, "run a pre-configuration playbook (can be repeated)")
+	configCmd.Flags().StringArrayP("pre-config", "c", []string{}, "run a pre-configuration playbook (can be repeated)")
+	_ = configCmd.Flags().MarkDeprecated("pre-config", "use --pre instead")
+	configCmd.Flags().StringArray("post", []string{}, "run a post-configuration playbook (can be repeated)")
 	configCmd.Flags().BoolP("k8s", "k", false, "Include k8s initialization before configuration")
 	configCmd.Flags().BoolP("pods", "p", false, "Show pods of the configured environment")
+
+	addAnsibleFlags(configCmd)
+}
+
+func ensureConfigPlaybook() error {
+	if !fileExists("playbooks/config.yml") {
+		return fmt.Errorf("can't find the 'playbooks/config.yml' playbook, " +
+			"use 'k8s-dev init --config-only' to create it and 'group_vars/cluster_config.yml'")
+	}
+
+	return nil
+}
+
+type configureStage struct {
+	Name     string
+	Playbook string
+}
+
+// configureStages returns the ordered chain of playbooks run by configure:
+// the pre-configuration playbooks from the project settings followed by those
+// from the command line, the optional k8s initialization, the configuration
+// playbook, and finally the post-configuration playbooks.
+func configureStages(cmd *cobra.Command) ([]configureStage, error) {
+	var stages []configureStage
+
+	settings, err := loadSettings()
+	if err != nil {
+		return stages, err
+	}
+
+	pre, _ := cmd.Flags().GetStringArray("pre")
+	preConfig, _ := cmd.Flags().GetStringArray("pre-config")
+
+	pre = append(pre, preConfig...)
+	post, _ := cmd.Flags().GetStringArray("post")
+	k8s, _ := cmd.Flags().GetBool("k8s")
+
+	for _, name := range slices.Concat(settings.Configure.Pre, pre) {
+		playbook, err := resolvePlaybook(name)
+		if err != nil {
+			return stages, err
+		}
+
+		stages = append(stages, configureStage{"pre-configuration", playbook})
+	}
+
+	if k8s {
+		stages = append(stages, configureStage{"initialization", "playbooks/init.yml"})
+	}
+
+	stages = append(stages, configureStage{"configuration", "playbooks/config.yml"})
+
+	for _, name := range slices.Concat(settings.Configure.Post, post) {
+		playbook, err := resolvePlaybook(name)
+		if err != nil {
+			return stages, err
+		}
+
+		stages = append(stages, configureStage{"post-configuration", playbook})
+	}
+
+	return stages, nil
 }
@@ -0,0 +1,352 @@
+/*
+Copyright © 2024 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	coverageExecuted = "executed"
+	coverageSkipped  = "skipped"
+	coverageMissed   = "missed"
+)
+
+// Task keywords that are not the module of the task.
+var taskKeywords = []string{
+	"any_errors_fatal", "args", "async", "become", "become_user", "changed_when",
+	"check_mode", "collections", "delay", "delegate_to", "diff", "environment",
+	"failed_when", "ignore_errors", "loop", "loop_control", "name", "no_log",
+	"notify", "poll", "register", "retries", "run_once", "tags", "until", "vars",
+	"when",
+}
+
+var includeActions = []string{
+	"include_tasks", "import_tasks", "ansible.builtin.include_tasks", "ansible.builtin.import_tasks",
+}
+
+var roleIncludeActions = []string{
+	"include_role", "import_role", "ansible.builtin.include_role", "ansible.builtin.import_role",
+}
+
+type coverageTask struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+}
+
+type roleCoverage struct {
+	Role     string         `json:"role"`
+	Tasks    []coverageTask `json:"tasks"`
+	Executed int            `json:"executed"`
+	Skipped  int            `json:"skipped"`
+	Missed   int            `json:"missed"`
+	Percent  float64        `json:"percent"`
+}
+
+// newRoleCoverage correlates the tasks declared by a role with the task
+// results of every test run of that role.
+func newRoleCoverage(role string, results []testResult) (roleCoverage, error) {
+	coverage := roleCoverage{Role: role}
+
+	dir := roleDir(role)
+
+	if len(dir) == 0 {
+		return coverage, fmt.Errorf("can't find the '%s' role", role)
+	}
+
+	// Ansible reports the real path of the task files.
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return coverage, err
+	}
+
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+
+	tasks, err := roleTasks(dir)
+	if err != nil {
+		return coverage, err
+	}
+
+	status := map[string]string{}
+
+	for _, r := range results {
+		if r.Role != role {
+			continue
+		}
+
+		for _, p := range r.Phases {
+			for _, task := range p.Tasks {
+				if status[task.Path] == coverageExecuted {
+					continue
+				}
+
+				if task.Status == "skipped" {
+					status[task.Path] = coverageSkipped
+				} else {
+					status[task.Path] = coverageExecuted
+				}
+			}
+		}
+	}
+
+	for _, task := range tasks {
+		task.Status = coverageMissed
+
+		if s, ok := status[fmt.Sprintf("%s:%d", makePath(dir, task.File), task.Line)]; ok {
+			task.Status = s
+		}
+
+		switch task.Status {
+		case coverageExecuted:
+			coverage.Executed++
+		case coverageSkipped:
+			coverage.Skipped++
+		default:
+			coverage.Missed++
+		}
+
+		coverage.Tasks = append(coverage.Tasks, task)
+	}
+
+	if len(coverage.Tasks) > 0 {
+		coverage.Percent = float64(coverage.Executed) * 100 / float64(len(coverage.Tasks))
+	}
+
+	return coverage, nil
+}
+
+// roleTasks returns the tasks of the role in dir, starting with tasks/main.yml
+// and following the task files it includes or imports, followed by the tasks
+// of any other task file that may be included dynamically.
+func roleTasks(dir string) ([]coverageTask, error) {
+	var tasks []coverageTask
+	var visited []string
+
+	var parse func(file string) error
+
+	parse = func(file string) error {
+		if slices.Contains(visited, file) || !fileExists(makePath(dir, file)) {
+			return nil
+		}
+
+		visited = append(visited, file)
+
+		content, err := os.ReadFile(makePath(dir, file))
+		if err != nil {
+			return err
+		}
+
+		var doc yaml.Node
+
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		if len(doc.Content) == 0 {
+			return nil
+		}
+
+		var walk func(list *yaml.Node) error
+
+		walk = func(list *yaml.Node) error {
+			if list.Kind != yaml.SequenceNode {
+				return nil
+			}
+
+			for _, item := range list.Content {
+				if item.Kind != yaml.MappingNode {
+					continue
+				}
+
+				values := map[string]*yaml.Node{}
+				var action string
+
+				for i := 0; i+1 < len(item.Content); i += 2 {
+					key := item.Content[i].Value
+					values[key] = item.Content[i+1]
+
+					if len(action) == 0 && !slices.Contains(taskKeywords, key) && !strings.HasPrefix(key, "with_") {
+						action = key
+					}
+				}
+
+				if _, ok := values["block"]; ok {
+					for _, section := range []string{"block", "rescue", "always"} {
+						if v, ok := values[section]; ok {
+							if err := walk(v); err != nil {
+								return err
+							}
+						}
+					}
+
+					continue
+				}
+
+				if slices.Contains(roleIncludeActions, action) {
+					continue
+				}
+
+				if slices.Contains(includeActions, action) {
+					include := values[action].Value
+
+					if values[action].Kind == yaml.MappingNode {
+						for i := 0; i+1 < len(values[action].Content); i += 2 {
+							if values[action].Content[i].Value == "file" {
+								include = values[action].Content[i+1].Value
+							}
+						}
+					}
+
+					if len(include) > 0 && !strings.Contains(include, "{{") {
+						if err := parse(filepath.ToSlash(filepath.Join("tasks", include))); err != nil {
+							return err
+						}
+					}
+
+					continue
+				}
+
+				name := action
+
+				if n, ok := values["name"]; ok {
+					name = n.Value
+				}
+
+				tasks = append(tasks, coverageTask{
+					Name: name,
+					File: file,
+					Line: item.Line,
+				})
+			}
+
+			return nil
+		}
+
+		return walk(doc.Content[0])
+	}
+
+	if err := parse("tasks/main.yml"); err != nil {
+		return tasks, err
+	}
+
+	if !dirExists(makePath(dir, "tasks")) {
+		return tasks, nil
+	}
+
+	err := filepath.WalkDir(makePath(dir, "tasks"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		if ext := filepath.Ext(path); ext != ".yml" && ext != ".yaml" {
+			return nil
+		}
+
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		return parse(filepath.ToSlash(relative))
+	})
+
+	return tasks, err
+}
+
+func printCoverage(coverage []roleCoverage) {
+	for _, c := range coverage {
+		printSubMessage(fmt.Sprintf("coverage of '%s': %d of %d task(s) executed (%.1f%%)",
+			c.Role, c.Executed, len(c.Tasks), c.Percent))
+
+		for _, task := range c.Tasks {
+			location := task.File + ":" + strconv.Itoa(task.Line)
+
+			switch task.Status {
+			case coverageMissed:
+				fmt.Printf("  %s %s %s\n", Red("MISSED "), location, task.Name)
+			case coverageSkipped:
+				fmt.Printf("  %s %s %s\n", Yellow("SKIPPED"), location, task.Name)
+			}
+		}
+	}
+}
+
+func writeCoverage(path, format string, coverage []roleCoverage) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if format == "html" {
+		err = coverageTemplate.Execute(file, coverage)
+	} else {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+
+		err = encoder.Encode(coverage)
+	}
+
+	if err == nil {
+		printSubMessage(fmt.Sprintf("coverage report written to '%s'", path))
+	}
+
+	return err
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>k8s-dev task coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.executed { background: #dff0d8; }
+.skipped { background: #fcf8e3; }
+.missed { background: #f2dede; }
+</style>
+</head>
+<body>
+<h1>Task coverage</h1>
+{{- range . }}
+<h2>{{ .Role }}: {{ .Executed }} of {{ len .Tasks }} task(s) executed ({{ printf "%.1f" .Percent }}%)</h2>
+<table>
+<tr><th>File</th><th>Line</th><th>Task</th><th>Status</th></tr>
+{{- range .Tasks }}
+<tr class="{{ .Status }}"><td>{{ .File }}</td><td>{{ .Line }}</td><td>{{ .Name }}</td><td>{{ .Status }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+</body>
+</html>
+`))
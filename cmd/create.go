@@ -66,6 +66,7 @@ var createCmd = &cobra.Command{
 		} else {
 			provision, _ := cmd.Flags().GetBool("provision")
 			cobra.CheckErr(vagrantUp(strings.Join(args, " "), provision))
+			cobra.CheckErr(registerProject())
 		}
 
 		deploy, _ := cmd.Flags().GetBool("deploy")
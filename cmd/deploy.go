@@ -33,9 +33,9 @@ var deployCmd = &cobra.Command{
 				"K8S_AUTH_VERIFY_SSL=false",
 			}
 
-			cobra.CheckErr(executeExternalProgramEnv("ansible-playbook", env, "playbooks/config.yml"))
+			cobra.CheckErr(runPlaybook(cmd, env, "playbooks/config.yml"))
 		} else {
-			cobra.CheckErr(executeExternalProgram("ansible-playbook", "playbooks/init.yml"))
+			cobra.CheckErr(runPlaybook(cmd, nil, "playbooks/init.yml"))
 		}
 
 		pods, _ := cmd.Flags().GetBool("pods")
@@ -62,6 +62,8 @@ var deployCmd = &cobra.Command{
 			}
 		} else {
 			miniKube_deploy = true
+
+			cobra.CheckErr(ensureConfigPlaybook())
 		}
 	},
 }
@@ -71,4 +73,6 @@ func init() {
 
 	deployCmd.Flags().BoolP("pods", "p", false, "Show pods of the deployed environment")
 	deployCmd.Flags().BoolP("force", "f", false, "force redeployment of the environment")
+
+	addAnsibleFlags(deployCmd)
 }
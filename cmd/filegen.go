@@ -57,6 +57,89 @@ skip_list:
 `))
 }
 
+func cluster_config_yml() error {
+	return createFile("group_vars/cluster_config.yml", []byte(`---
+# In-cluster configuration applied by playbooks/config.yml.
+cluster_namespaces: []
+#  - monitoring
+cluster_storage_classes: []
+#  - name: local-path-retain
+#    provisioner: rancher.io/local-path
+#    reclaim_policy: Retain
+#    volume_binding_mode: WaitForFirstConsumer
+#    default: false
+cluster_helm_repositories: []
+#  - name: ingress-nginx
+#    url: https://kubernetes.github.io/ingress-nginx
+cluster_helm_releases: []
+#  - name: ingress-nginx
+#    chart: ingress-nginx/ingress-nginx
+#    version: "4.11.1"
+#    namespace: ingress-nginx
+#    values: {}
+`))
+}
+
+func config_yml() error {
+	return createFile("playbooks/config.yml", []byte(`---
+- name: Configure kubernetes cluster
+  hosts: localhost
+  connection: local
+  gather_facts: false
+
+  vars_files:
+    - ../group_vars/cluster_config.yml
+
+  vars:
+    k8s_config: "{{ playbook_dir }}/../.kubectl.cfg"
+
+  tasks:
+    - name: Ensure namespaces exist
+      kubernetes.core.k8s:
+        kubeconfig: "{{ k8s_config }}"
+        api_version: v1
+        kind: Namespace
+        name: "{{ item }}"
+        state: present
+      loop: "{{ cluster_namespaces }}"
+
+    - name: Ensure storage classes exist
+      kubernetes.core.k8s:
+        kubeconfig: "{{ k8s_config }}"
+        state: present
+        definition:
+          apiVersion: storage.k8s.io/v1
+          kind: StorageClass
+          metadata:
+            name: "{{ item.name }}"
+            annotations:
+              storageclass.kubernetes.io/is-default-class: "{{ item.default | default(false) | string | lower }}"
+          provisioner: "{{ item.provisioner }}"
+          reclaimPolicy: "{{ item.reclaim_policy | default('Delete') }}"
+          volumeBindingMode: "{{ item.volume_binding_mode | default('WaitForFirstConsumer') }}"
+          parameters: "{{ item.parameters | default({}) }}"
+      loop: "{{ cluster_storage_classes }}"
+
+    - name: Ensure Helm repositories are present
+      kubernetes.core.helm_repository:
+        name: "{{ item.name }}"
+        repo_url: "{{ item.url }}"
+      loop: "{{ cluster_helm_repositories }}"
+
+    - name: Ensure base Helm releases are deployed
+      kubernetes.core.helm:
+        kubeconfig: "{{ k8s_config }}"
+        name: "{{ item.name }}"
+        chart_ref: "{{ item.chart }}"
+        chart_version: "{{ item.version | default(omit) }}"
+        release_namespace: "{{ item.namespace | default('default') }}"
+        create_namespace: true
+        values: "{{ item.values | default({}) }}"
+        wait: true
+      loop: "{{ cluster_helm_releases }}"
+`))
+}
+
 func createFolder(folderPath string) error {
 	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
 		printSubMessage("creating development folder...")
@@ -153,6 +236,10 @@ func init_yml() error {
 `))
 }
 
+// hostAddress is the address of the host on the private network of the
+// Vagrant nodes, which are given the addresses that follow it.
+const hostAddress = "192.168.57.1"
+
 func inventory_file(servers, agents int) error {
 	var sb strings.Builder
 
@@ -309,6 +396,7 @@ func testNeedForce(force bool) error {
 		"ansible.cfg",
 		".ansible-lint",
 		"host.ini",
+		"k8s-dev.yml",
 		"requirements.yml",
 		"Vagrantfile",
 	}
@@ -328,8 +416,25 @@ func testNeedForce(force bool) error {
 	return nil
 }
 
-func vagrant_file(servers, agents int, box string) error {
-	filevars := fmt.Sprintf("IMAGE_NAME = \"%s\"\nSERVER_NUMBER = %d\nAGENT_NUMBER = %d\n\n", box, servers, agents)
+func testConfigNeedForce(force bool) error {
+	msg := "'%s' already exists, would be over written, and force was not provided."
+
+	files := []string{
+		"group_vars/cluster_config.yml",
+		"playbooks/config.yml",
+	}
+
+	for _, f := range files {
+		if fileExists(f) && !force {
+			return fmt.Errorf(msg, f)
+		}
+	}
+
+	return nil
+}
+
+func vagrant_file(servers, agents int, box, version string) error {
+	filevars := fmt.Sprintf("IMAGE_NAME = \"%s\"\nBOX_VERSION = \"%s\"\nSERVER_NUMBER = %d\nAGENT_NUMBER = %d\n\n", box, version, servers, agents)
 
 	return createFile("Vagrantfile", []byte(filevars+`Vagrant.configure("2") do |config|
   config.ssh.insert_key = false
@@ -349,6 +454,7 @@ func vagrant_file(servers, agents int, box string) error {
   (1..SERVER_NUMBER).each do |i|
     config.vm.define "control-#{i}" do |c|
       c.vm.box = IMAGE_NAME
+      c.vm.box_version = BOX_VERSION unless BOX_VERSION.empty?
       c.vm.hostname = "control-#{i}"
       c.vm.network "private_network", ip: "192.168.57.#{i + 10}"
       c.vm.network :forwarded_port, guest: 22, host: "80#{i + 10}", id: 'ssh'
@@ -361,6 +467,7 @@ func vagrant_file(servers, agents int, box string) error {
   (1..AGENT_NUMBER).each do |i|
     config.vm.define "work-#{i}" do |c|
       c.vm.box = IMAGE_NAME
+      c.vm.box_version = BOX_VERSION unless BOX_VERSION.empty?
       c.vm.hostname = "work-#{i}"
       c.vm.network "private_network", ip: "192.168.57.#{i + 20}"
       c.vm.network :forwarded_port, guest: 22, host: "80#{i + 20}", id: 'ssh'
@@ -26,9 +26,7 @@ var helmCmd = &cobra.Command{
 	Short: "Use helm in the Kubernetes development environment",
 	Long:  "Use helm in the Kubernetes development environment",
 	Run: func(cmd *cobra.Command, args []string) {
-		params := append(args, "--kubeconfig=./.kubectl.cfg", "--kube-context=default")
-
-		output, err := executeCommand("helm", params...)
+		output, err := executeCommand("helm", helmParams(args...)...)
 
 		cobra.CheckErr(err)
 
@@ -36,11 +34,22 @@ var helmCmd = &cobra.Command{
 	},
 	PreRun: func(cmd *cobra.Command, args []string) {
 		ensureRootDirectory()
-		cobra.CheckErr(ensureVagrantfile())
-		cobra.CheckErr(ensureKubectlfile())
+		cobra.CheckErr(ensureEnvironment())
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(helmCmd)
 }
+
+// helmParams adds the project's kubectl configuration to params. Minikube
+// environments use the current context of the configuration.
+func helmParams(params ...string) []string {
+	params = append(params, "--kubeconfig=./.kubectl.cfg")
+
+	if isVagrantEnv() {
+		params = append(params, "--kube-context=default")
+	}
+
+	return params
+}
@@ -0,0 +1,279 @@
+/*
+Copyright © 2024 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+var imageCmd = &cobra.Command{
+	Use:   "image",
+	Short: "Build container images and load them into the development environment",
+	Long: `Build container images and load them into every node of the Kubernetes development environment,
+so charts and manifests can use freshly built images without pushing them to a registry.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		ensureRootDirectory()
+		cobra.CheckErr(ensureEnvironment())
+	},
+}
+
+var imageBuildCmd = &cobra.Command{
+	Use:   "build <dir>",
+	Short: "Build a container image and load it into the development environment",
+	Long:  "Build a container image from a directory with a Dockerfile and load it into every node",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tag, _ := cmd.Flags().GetString("tag")
+		engine, _ := cmd.Flags().GetString("engine")
+
+		printMessage(fmt.Sprintf("Building '%s' from '%s'...", tag, args[0]))
+
+		cobra.CheckErr(executeExternalProgram(engine, "build", "--tag", tag, args[0]))
+		cobra.CheckErr(loadImages(cmd, []string{tag}))
+	},
+}
+
+var imageLoadCmd = &cobra.Command{
+	Use:   "load <image|tarball>...",
+	Short: "Load container images into the development environment",
+	Long: `Load local container images, or image tarballs, into every node of the development environment.
+Minikube loads them with 'minikube image load'. For Vagrant, the images are saved to a tarball that is
+streamed over SSH into 'k3s ctr images import' on each node of hosts.ini.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(loadImages(cmd, args))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(imageCmd)
+
+	imageCmd.AddCommand(imageBuildCmd)
+	imageCmd.AddCommand(imageLoadCmd)
+
+	imageCmd.PersistentFlags().String("engine", "docker", "container engine used to build and save images")
+	imageCmd.PersistentFlags().String("target", "k3s_cluster", "group or node of the inventory to load the images into")
+	imageCmd.PersistentFlags().IntP("parallel", "j", 0, "number of nodes to load the images into at the same time (default is all)")
+
+	imageBuildCmd.Flags().StringP("tag", "t", "", "name and tag of the image")
+	_ = imageBuildCmd.MarkFlagRequired("tag")
+}
+
+// loadImages loads images, which are either image names or tarballs, into the
+// nodes of the development environment.
+func loadImages(cmd *cobra.Command, images []string) error {
+	if isMinikubeEnv() {
+		for _, i := range images {
+			printMessage(fmt.Sprintf("Loading '%s' into Minikube...", i))
+
+			if err := runMinikube("image", "load", i); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	inv, err := readInventory("hosts.ini")
+	if err != nil {
+		return err
+	}
+
+	target, _ := cmd.Flags().GetString("target")
+
+	if err := inv.validateTarget(target); err != nil {
+		return err
+	}
+
+	nodes := []string{target}
+
+	if hosts, ok := inv.Groups[target]; ok {
+		nodes = hosts
+	}
+
+	var tarballs, names []string
+
+	for _, i := range images {
+		if fileExists(i) {
+			tarballs = append(tarballs, i)
+		} else {
+			names = append(names, i)
+		}
+	}
+
+	if len(names) > 0 {
+		engine, _ := cmd.Flags().GetString("engine")
+
+		tarball := makePath(".tmp", "image-"+labelValue(strings.Join(names, "-"))+".tar")
+
+		if err := ensureDir(".tmp"); err != nil {
+			return err
+		}
+
+		defer func() {
+			_ = removeFile(tarball)
+		}()
+
+		printMessage(fmt.Sprintf("Saving %s...", strings.Join(names, ", ")))
+
+		params := append([]string{"save", "--output", tarball}, names...)
+
+		if _, err := executeCommandOutput(engine, params...); err != nil {
+			return err
+		}
+
+		tarballs = append(tarballs, tarball)
+	}
+
+	parallel, _ := cmd.Flags().GetInt("parallel")
+
+	if parallel < 1 {
+		parallel = len(nodes)
+	}
+
+	for _, t := range tarballs {
+		printMessage(fmt.Sprintf("Loading '%s' into %d node(s)...", t, len(nodes)))
+
+		if err := importTarball(inv, nodes, t, parallel); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importTarball streams tarball into the containerd of each node, at most
+// parallel nodes at a time.
+func importTarball(inv inventory, nodes []string, tarball string, parallel int) error {
+	info, err := os.Stat(tarball)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(nodes))
+
+	var wg sync.WaitGroup
+
+	slots := make(chan struct{}, parallel)
+
+	for i, node := range nodes {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			slots <- struct{}{}
+			errs[i] = importNodeTarball(inv, node, tarball, info.Size())
+			<-slots
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func importNodeTarball(inv inventory, node, tarball string, size int64) error {
+	file, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	var output bytes.Buffer
+
+	ssh := exec.Command("ssh", append(sshParams(inv, node), "sudo", "k3s", "ctr", "--namespace", "k8s.io", "images", "import", "-")...)
+	ssh.Stdin = &progressReader{Reader: file, Name: node, Size: size}
+	ssh.Stdout = &output
+	ssh.Stderr = &output
+
+	if err := ssh.Run(); err != nil {
+		return fmt.Errorf("unable to load '%s' into %s: %s", tarball, node, strings.TrimSpace(output.String()))
+	}
+
+	printSubMessage(fmt.Sprintf("%s: loaded '%s'", node, tarball))
+
+	return nil
+}
+
+// sshParams returns the parameters used to connect to node with ssh, using
+// the same connection variables as Ansible.
+func sshParams(inv inventory, node string) []string {
+	host := inv.HostVar(node, "ansible_host")
+
+	if len(host) == 0 {
+		host = node
+	}
+
+	if user := inv.HostVar(node, "ansible_user"); len(user) > 0 {
+		host = user + "@" + host
+	}
+
+	params := []string{"-o", "BatchMode=yes"}
+
+	if key := inv.HostVar(node, "ansible_ssh_private_key_file"); len(key) > 0 {
+		if strings.HasPrefix(key, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				key = makePath(home, key[2:])
+			}
+		}
+
+		params = append(params, "-i", key)
+	}
+
+	params = append(params, strings.Fields(inv.HostVar(node, "ansible_ssh_common_args"))...)
+
+	return append(params, host)
+}
+
+// progressReader reports how much of an image tarball has been sent to a node
+// in steps of ten percent.
+type progressReader struct {
+	io.Reader
+	Name string
+	Size int64
+
+	read     int64
+	reported int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+
+	r.read += int64(n)
+
+	if r.Size > 0 {
+		percent := r.read * 100 / r.Size / 10 * 10
+
+		if percent > r.reported {
+			r.reported = percent
+
+			printSubMessage(fmt.Sprintf("%s: %d%%", r.Name, percent))
+		}
+	}
+
+	return n, err
+}
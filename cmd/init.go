@@ -32,6 +32,20 @@ var initCmd = &cobra.Command{
 		ensureRootDirectory()
 
 		force, _ := cmd.Flags().GetBool("force")
+		configOnly, _ := cmd.Flags().GetBool("config-only")
+
+		if configOnly {
+			cobra.CheckErr(testConfigNeedForce(force))
+
+			printMessage("Initializing the development cluster configuration...")
+
+			cobra.CheckErr(makeDirectory("group_vars"))
+			cobra.CheckErr(makeDirectory("playbooks"))
+			cobra.CheckErr(cluster_config_yml())
+			cobra.CheckErr(config_yml())
+
+			return
+		}
 
 		cobra.CheckErr(testNeedForce(force))
 
@@ -47,14 +61,22 @@ var initCmd = &cobra.Command{
 		servers, _ := cmd.Flags().GetInt("servers")
 		agents, _ := cmd.Flags().GetInt("agents")
 		box, _ := cmd.Flags().GetString("box")
+		version, _ := cmd.Flags().GetString("box-version")
 
 		cobra.CheckErr(inventory_file(servers, agents))
 		cobra.CheckErr(requirements_yml())
-		cobra.CheckErr(vagrant_file(servers, agents, box))
+		cobra.CheckErr(vagrant_file(servers, agents, box, version))
+		cobra.CheckErr(saveSettings(projectSettings{
+			Box:        box,
+			BoxVersion: version,
+		}))
+		cobra.CheckErr(registerProject())
 
 		cobra.CheckErr(all_yml())
 		cobra.CheckErr(k3s_cluster_yml())
+		cobra.CheckErr(cluster_config_yml())
 
+		cobra.CheckErr(config_yml())
 		cobra.CheckErr(init_yml())
 		cobra.CheckErr(reboot_yml())
 		cobra.CheckErr(reset_yml())
@@ -75,6 +97,8 @@ func init() {
 	initCmd.Flags().IntP("agents", "a", 3, "number of work agent nodes")
 	initCmd.Flags().IntP("servers", "s", 2, "number of control servers")
 	initCmd.Flags().StringP("box", "b", "debian/bookworm64", "vagrant box image")
+	initCmd.Flags().String("box-version", "", "pin the vagrant box image to a version")
 
 	initCmd.Flags().BoolP("force", "f", false, "overwrite an existing development folder")
+	initCmd.Flags().Bool("config-only", false, "only create the cluster configuration playbook and variables")
 }
@@ -0,0 +1,151 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// inventory is the subset of an Ansible inventory, like the hosts.ini created
+// by init, that k8s-dev needs to know about. It's read with ansible-inventory
+// so every inventory format and the group variables are supported.
+type inventory struct {
+	Groups map[string][]string
+	Hosts  map[string]map[string]string
+}
+
+func readInventory(path string) (inventory, error) {
+	inv := inventory{
+		Groups: map[string][]string{},
+		Hosts:  map[string]map[string]string{},
+	}
+
+	output, err := executeCommandOutput("ansible-inventory", "--inventory", path, "--list")
+	if err != nil {
+		return inv, fmt.Errorf("unable to read the '%s' inventory: %w", path, err)
+	}
+
+	var list map[string]json.RawMessage
+
+	if err := json.Unmarshal([]byte(output), &list); err != nil {
+		return inv, err
+	}
+
+	var meta struct {
+		HostVars map[string]map[string]interface{} `json:"hostvars"`
+	}
+
+	children := map[string][]string{}
+
+	for name, content := range list {
+		if name == "_meta" {
+			if err := json.Unmarshal(content, &meta); err != nil {
+				return inv, err
+			}
+
+			continue
+		}
+
+		var group struct {
+			Hosts    []string `json:"hosts"`
+			Children []string `json:"children"`
+		}
+
+		if err := json.Unmarshal(content, &group); err != nil {
+			return inv, err
+		}
+
+		inv.Groups[name] = group.Hosts
+		children[name] = group.Children
+
+		for _, h := range group.Hosts {
+			if inv.Hosts[h] == nil {
+				inv.Hosts[h] = map[string]string{}
+			}
+		}
+	}
+
+	for host, vars := range meta.HostVars {
+		if inv.Hosts[host] == nil {
+			inv.Hosts[host] = map[string]string{}
+		}
+
+		for k, v := range vars {
+			if value, ok := v.(string); ok {
+				inv.Hosts[host][k] = value
+			} else {
+				inv.Hosts[host][k] = fmt.Sprint(v)
+			}
+		}
+	}
+
+	var resolve func(group string, seen []string) []string
+
+	resolve = func(group string, seen []string) []string {
+		hosts := slices.Clone(inv.Groups[group])
+
+		for _, child := range children[group] {
+			if slices.Contains(seen, child) {
+				continue
+			}
+
+			for _, h := range resolve(child, append(seen, child)) {
+				if !slices.Contains(hosts, h) {
+					hosts = append(hosts, h)
+				}
+			}
+		}
+
+		return hosts
+	}
+
+	resolved := map[string][]string{}
+
+	for group := range inv.Groups {
+		resolved[group] = resolve(group, []string{group})
+	}
+
+	inv.Groups = resolved
+
+	return inv, nil
+}
+
+// HostVar returns the value of a variable for host, including the variables
+// inherited from its groups.
+func (inv inventory) HostVar(host, name string) string {
+	return inv.Hosts[host][name]
+}
+
+// validateTarget ensures target is either one of the cluster groups or a
+// node defined in the inventory.
+func (inv inventory) validateTarget(target string) error {
+	groups := []string{"k3s_cluster", "master", "node"}
+
+	if slices.Contains(groups, target) {
+		if _, ok := inv.Groups[target]; ok {
+			return nil
+		}
+	}
+
+	if _, ok := inv.Hosts[target]; ok {
+		return nil
+	}
+
+	return fmt.Errorf("'%s' is not a node or one of the %s groups in the inventory", target, strings.Join(groups, ", "))
+}
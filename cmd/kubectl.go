@@ -0,0 +1,76 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+func kubectl(params ...string) (string, error) {
+	return executeCommandOutput("kubectl", kubectlParams(params...)...)
+}
+
+func kubectlParams(params ...string) []string {
+	return append([]string{
+		"--kubeconfig=./.kubectl.cfg",
+		"--insecure-skip-tls-verify=true",
+	}, params...)
+}
+
+func kubectlJSON(v interface{}, params ...string) error {
+	output, err := kubectl(append(params, "--output=json")...)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(output), v)
+}
+
+// ensureNamespace creates the namespace if it doesn't exist and applies the
+// labels, given as key=value, to it.
+func ensureNamespace(name string, labels ...string) error {
+	output, err := kubectl("get", "namespace", name, "--ignore-not-found", "--output=name")
+	if err != nil {
+		return err
+	}
+
+	if len(strings.TrimSpace(output)) == 0 {
+		if _, err = kubectl("create", "namespace", name); err != nil {
+			return err
+		}
+	}
+
+	if len(labels) > 0 {
+		_, err = kubectl(append([]string{"label", "namespace", name, "--overwrite"}, labels...)...)
+	}
+
+	return err
+}
+
+// kubectlResource converts the apiVersion and kind of an object into the
+// fully qualified resource name understood by kubectl.
+func kubectlResource(apiVersion, kind string) string {
+	kind = strings.ToLower(kind)
+
+	group, version, found := strings.Cut(apiVersion, "/")
+
+	if !found {
+		return kind
+	}
+
+	return kind + "." + version + "." + group
+}
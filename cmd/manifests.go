@@ -0,0 +1,231 @@
+/*
+Copyright © 2024 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifestDocument is a single object of a rendered manifest.
+type manifestDocument struct {
+	Key     string
+	Content string
+}
+
+// normalizeManifests splits rendered manifests into their objects, sorted by
+// kind, namespace and name, with comments removed and keys in a stable order
+// so two renderings can be compared.
+func normalizeManifests(manifest string) ([]manifestDocument, error) {
+	var documents []manifestDocument
+
+	decoder := yaml.NewDecoder(strings.NewReader(manifest))
+
+	for {
+		var object map[string]interface{}
+
+		err := decoder.Decode(&object)
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return documents, err
+		}
+
+		if len(object) == 0 {
+			continue
+		}
+
+		var buf bytes.Buffer
+
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+
+		if err := encoder.Encode(object); err != nil {
+			return documents, err
+		}
+
+		kind, _ := object["kind"].(string)
+		key := kind + " " + objectField(object, "metadata", "name")
+
+		if namespace := objectField(object, "metadata", "namespace"); len(namespace) > 0 {
+			key = kind + " " + namespace + "/" + objectField(object, "metadata", "name")
+		}
+
+		documents = append(documents, manifestDocument{
+			Key:     key,
+			Content: buf.String(),
+		})
+	}
+
+	sort.SliceStable(documents, func(i, j int) bool {
+		return documents[i].Key < documents[j].Key
+	})
+
+	return documents, nil
+}
+
+func joinManifests(documents []manifestDocument) string {
+	var sb strings.Builder
+
+	for _, d := range documents {
+		sb.WriteString("---\n")
+		sb.WriteString(d.Content)
+	}
+
+	return sb.String()
+}
+
+// diffManifests returns a readable diff of the objects added, removed or
+// changed between two normalized manifests, or nothing when they are equal.
+func diffManifests(before, after []manifestDocument) []string {
+	var lines []string
+
+	index := func(documents []manifestDocument) map[string]string {
+		m := map[string]string{}
+
+		for _, d := range documents {
+			m[d.Key] = d.Content
+		}
+
+		return m
+	}
+
+	b := index(before)
+	a := index(after)
+
+	var keys []string
+
+	for k := range b {
+		keys = append(keys, k)
+	}
+
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		previous, found := b[k]
+		current, exists := a[k]
+
+		switch {
+		case !found:
+			lines = append(lines, Green("+ "+k))
+		case !exists:
+			lines = append(lines, Red("- "+k))
+		case previous != current:
+			lines = append(lines, Yellow("~ "+k))
+			lines = append(lines, diffLines(strings.Split(previous, "\n"), strings.Split(current, "\n"))...)
+		}
+	}
+
+	return lines
+}
+
+// diffLines compares two lists of lines and returns the changed lines with
+// up to three lines of unchanged context around them.
+func diffLines(before, after []string) []string {
+	const context = 3
+
+	n, m := len(before), len(after)
+	lcs := make([][]int, n+1)
+
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte
+		line string
+	}
+
+	var edits []edit
+
+	i, j := 0, 0
+
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && before[i] == after[j]:
+			edits = append(edits, edit{' ', before[i]})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', before[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', after[j]})
+			j++
+		}
+	}
+
+	var lines []string
+
+	last := -1
+
+	for k, e := range edits {
+		near := false
+
+		for d := max(0, k-context); d <= min(len(edits)-1, k+context); d++ {
+			if edits[d].op != ' ' {
+				near = true
+				break
+			}
+		}
+
+		if !near {
+			continue
+		}
+
+		if last >= 0 && k > last+1 {
+			lines = append(lines, "    ...")
+		}
+
+		last = k
+
+		switch e.op {
+		case '+':
+			lines = append(lines, Green(fmt.Sprintf("    + %s", e.line)))
+		case '-':
+			lines = append(lines, Red(fmt.Sprintf("    - %s", e.line)))
+		default:
+			lines = append(lines, fmt.Sprintf("      %s", e.line))
+		}
+	}
+
+	return lines
+}
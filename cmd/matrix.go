@@ -0,0 +1,357 @@
+/*
+Copyright © 2024 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type matrixEntry struct {
+	Box        string `json:"box"`
+	K3sVersion string `json:"k3s_version,omitempty"`
+	CNI        string `json:"cni"`
+}
+
+type matrixResult struct {
+	matrixEntry
+	Passed   bool    `json:"passed"`
+	Stage    string  `json:"stage,omitempty"`
+	Message  string  `json:"message,omitempty"`
+	Duration float64 `json:"duration"`
+}
+
+var matrixCmd = &cobra.Command{
+	Use:   "matrix [role|namespace.collection.role|playbook...]",
+	Short: "Test roles against every combination of boxes, k3s versions and CNI plug-ins",
+	Long: `Test roles against every combination of boxes, k3s versions and CNI plug-ins declared in the
+matrix section of k8s-dev.yml or given as flags. Each combination is created, deployed, tested and
+destroyed in turn in the Vagrant environment of the project, then the results are shown as a grid.
+Without roles, the tests listed in the matrix section are run, or every role when there are none.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		settings, err := loadSettings()
+		cobra.CheckErr(err)
+
+		box, version, err := vagrantfileBox(".")
+		cobra.CheckErr(err)
+
+		boxes, _ := cmd.Flags().GetStringArray("box")
+		versions, _ := cmd.Flags().GetStringArray("k3s-version")
+		cnis, _ := cmd.Flags().GetStringArray("cni")
+
+		boxes = matrixValues(boxes, settings.Matrix.Boxes, box)
+		versions = matrixValues(versions, settings.Matrix.K3sVersions, "")
+		cnis = matrixValues(cnis, settings.Matrix.CNI, "flannel")
+
+		for _, cni := range cnis {
+			cobra.CheckErr(validateK3sNetwork(cni))
+		}
+
+		tests := args
+
+		if len(tests) == 0 {
+			tests = settings.Matrix.Tests
+		}
+
+		if len(tests) == 0 {
+			tests = []string{"--all"}
+		}
+
+		reportDir, _ := cmd.Flags().GetString("report-dir")
+
+		var entries []matrixEntry
+
+		for _, b := range boxes {
+			for _, v := range versions {
+				for _, c := range cnis {
+					entries = append(entries, matrixEntry{
+						Box:        b,
+						K3sVersion: v,
+						CNI:        c,
+					})
+				}
+			}
+		}
+
+		content, err := readFile("Vagrantfile")
+		cobra.CheckErr(err)
+
+		var once sync.Once
+
+		restore := func() {
+			once.Do(func() {
+				if err := os.WriteFile("Vagrantfile", []byte(content), 0644); err != nil {
+					printSubMessage(fmt.Sprintf("unable to restore the Vagrantfile: %s", err))
+				}
+			})
+		}
+
+		defer restore()
+
+		// An interrupt stops the matrix once the current combination has been
+		// destroyed, and a second one exits immediately.
+		var interrupted atomic.Bool
+
+		interrupt := make(chan os.Signal, 2)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+		go func() {
+			<-interrupt
+			interrupted.Store(true)
+			printSubMessage("interrupted, destroying the current environment...")
+
+			<-interrupt
+			restore()
+			os.Exit(130)
+		}()
+
+		var results []matrixResult
+
+		for i, e := range entries {
+			if interrupted.Load() {
+				break
+			}
+
+			printMessage(fmt.Sprintf("Matrix %d of %d: %s...", i+1, len(entries), e))
+
+			pin := ""
+
+			if e.Box == box {
+				pin = version
+			}
+
+			results = append(results, runMatrixEntry(e, pin, tests, reportDir, interrupted.Load))
+		}
+
+		signal.Stop(interrupt)
+		restore()
+
+		fmt.Println()
+		printMatrixResults(results)
+
+		if len(reportDir) > 0 {
+			content, err := json.MarshalIndent(results, "", "  ")
+			cobra.CheckErr(err)
+			cobra.CheckErr(ensureDir(reportDir))
+			cobra.CheckErr(os.WriteFile(makePath(reportDir, "matrix.json"), content, 0644))
+		}
+
+		failed := 0
+
+		for _, r := range results {
+			if !r.Passed {
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			cobra.CheckErr(fmt.Errorf("%d of %d matrix combination(s) failed", failed, len(results)))
+		}
+
+		if interrupted.Load() {
+			cobra.CheckErr(fmt.Errorf("the matrix was interrupted after %d of %d combination(s)", len(results), len(entries)))
+		}
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		ensureRootDirectory()
+
+		if isMinikubeEnv() {
+			cobra.CheckErr(errors.New("the matrix requires a Vagrant environment"))
+		}
+
+		cobra.CheckErr(ensureVagrantfile())
+
+		if isVagrantEnv() {
+			printSubMessage("kubernetes environment is currently deployed")
+
+			force, _ := cmd.Flags().GetBool("force")
+
+			if !force {
+				force = askForConfirmation("Are you sure you want to destroy the environment?")
+			}
+
+			if !force {
+				cobra.CheckErr(errors.New("the matrix cannot run while the environment exists"))
+			}
+
+			cobra.CheckErr(vagrantDestroy())
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(matrixCmd)
+
+	matrixCmd.Flags().StringArray("box", []string{}, "vagrant box image to test (can be repeated)")
+	matrixCmd.Flags().StringArray("k3s-version", []string{}, "k3s version to test (can be repeated)")
+	matrixCmd.Flags().StringArray("cni", []string{}, "CNI plug-in to test: calico, cilium, flannel (can be repeated)")
+	matrixCmd.Flags().String("report-dir", "", "write the test reports of each combination and the matrix results to this directory")
+	matrixCmd.Flags().BoolP("force", "f", false, "destroy the current environment without confirmation")
+}
+
+func (e matrixEntry) String() string {
+	version := e.K3sVersion
+
+	if len(version) == 0 {
+		version = "default k3s"
+	}
+
+	return fmt.Sprintf("%s, %s, %s", e.Box, version, e.CNI)
+}
+
+func (e matrixEntry) Name() string {
+	return regexp.MustCompile(`[^A-Za-z0-9_.-]+`).ReplaceAllString(strings.Join([]string{e.Box, e.K3sVersion, e.CNI}, "-"), "_")
+}
+
+// deployVars returns the variables passed to the init playbook to deploy the
+// k3s version and CNI plug-in of the entry.
+func (e matrixEntry) deployVars() (string, error) {
+	vars := map[string]string{}
+
+	if len(e.K3sVersion) > 0 {
+		vars["k3s_version"] = e.K3sVersion
+	}
+
+	switch e.CNI {
+	case "calico":
+		vars["calico_iface"] = "{{ flannel_iface }}"
+	case "cilium":
+		vars["cilium_iface"] = "{{ flannel_iface }}"
+	}
+
+	content, err := json.Marshal(vars)
+
+	return string(content), err
+}
+
+func runMatrixEntry(e matrixEntry, version string, tests []string, reportDir string, interrupted func() bool) matrixResult {
+	start := time.Now()
+	result := matrixResult{matrixEntry: e}
+
+	stage := func(name string, run func() error) bool {
+		if len(result.Stage) > 0 {
+			return false
+		}
+
+		if interrupted() {
+			result.Stage = name
+			result.Message = "interrupted"
+
+			return false
+		}
+
+		if err := run(); err != nil {
+			result.Stage = name
+			result.Message = err.Error()
+
+			return false
+		}
+
+		return true
+	}
+
+	stage("create", func() error {
+		if err := setVagrantfileBox(e.Box, version); err != nil {
+			return err
+		}
+
+		return vagrantUp("", true)
+	})
+
+	stage("deploy", func() error {
+		vars, err := e.deployVars()
+		if err != nil {
+			return err
+		}
+
+		return executeExternalProgram("ansible-playbook", "--extra-vars", vars, "playbooks/init.yml")
+	})
+
+	stage("test", func() error {
+		program, err := os.Executable()
+		if err != nil {
+			return err
+		}
+
+		params := append([]string{"test"}, tests...)
+
+		if len(reportDir) > 0 {
+			params = append(params, "--report-dir", makePath(reportDir, e.Name()))
+		}
+
+		return executeExternalProgram(program, params...)
+	})
+
+	if err := vagrantDestroy(); err != nil && len(result.Stage) == 0 {
+		result.Stage = "destroy"
+		result.Message = err.Error()
+	}
+
+	result.Passed = len(result.Stage) == 0
+	result.Duration = time.Since(start).Seconds()
+
+	return result
+}
+
+func matrixValues(flag, settings []string, fallback string) []string {
+	if len(flag) > 0 {
+		return flag
+	}
+
+	if len(settings) > 0 {
+		return settings
+	}
+
+	return []string{fallback}
+}
+
+func printMatrixResults(results []matrixResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "BOX\tK3S VERSION\tCNI\tRESULT\tDURATION")
+
+	for _, r := range results {
+		version := r.K3sVersion
+
+		if len(version) == 0 {
+			version = "default"
+		}
+
+		status := "PASSED"
+
+		if !r.Passed {
+			status = fmt.Sprintf("FAILED (%s)", r.Stage)
+		}
+
+		duration := time.Duration(r.Duration * float64(time.Second)).Round(time.Second)
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Box, version, r.CNI, status, duration)
+	}
+
+	w.Flush()
+}
@@ -16,8 +16,12 @@ limitations under the License.
 package cmd
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 func executeExternalProgram(program string, params ...string) error {
@@ -34,6 +38,35 @@ func executeExternalProgramEnv(program string, env []string, params ...string) e
 	return cmd.Run()
 }
 
+func executeExternalProgramCapture(program string, env []string, params ...string) (string, error) {
+	var output bytes.Buffer
+
+	cmd := exec.Command(program, params...)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+	cmd.Env = append(os.Environ(), env...)
+
+	err := cmd.Run()
+
+	return output.String(), err
+}
+
+// executeExternalProgramLog writes the output of program to log rather than
+// the console so programs running concurrently don't interleave their output.
+func executeExternalProgramLog(program string, env []string, log io.Writer, params ...string) (string, error) {
+	var output bytes.Buffer
+
+	cmd := exec.Command(program, params...)
+	cmd.Stderr = io.MultiWriter(log, &output)
+	cmd.Stdout = io.MultiWriter(log, &output)
+	cmd.Env = append(os.Environ(), env...)
+
+	err := cmd.Run()
+
+	return output.String(), err
+}
+
 func executeCommand(program string, params ...string) (string, error) {
 	return executeCommandEnv(program, []string{""}, params...)
 }
@@ -47,3 +80,20 @@ func executeCommandEnv(program string, env []string, params ...string) (string,
 
 	return string(output[:]), err
 }
+
+// executeCommandOutput returns only the standard output of program so it can
+// be parsed, folding any standard error into the returned error.
+func executeCommandOutput(program string, params ...string) (string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(program, params...)
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+
+	if err != nil && stderr.Len() > 0 {
+		err = errors.New(strings.TrimSpace(stderr.String()))
+	}
+
+	return string(output), err
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// projectsFile records every development folder k8s-dev has initialized or
+// created on this machine so commands can look beyond the current folder.
+func projectsFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return makePath(dir, "k8s-dev", "projects"), nil
+}
+
+func knownProjects() ([]string, error) {
+	var projects []string
+
+	path, err := projectsFile()
+	if err != nil {
+		return projects, err
+	}
+
+	if fileExists(path) {
+		content, err := readFile(path)
+		if err != nil {
+			return projects, err
+		}
+
+		for _, line := range strings.Split(content, "\n") {
+			line = strings.TrimSpace(line)
+
+			if len(line) > 0 && fileExists(makePath(line, "Vagrantfile")) && !slices.Contains(projects, line) {
+				projects = append(projects, line)
+			}
+		}
+	}
+
+	current, err := os.Getwd()
+	if err != nil {
+		return projects, err
+	}
+
+	if fileExists("Vagrantfile") && !slices.Contains(projects, current) {
+		projects = append(projects, current)
+	}
+
+	return projects, nil
+}
+
+func registerProject() error {
+	current, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	projects, err := knownProjects()
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(projects, current) {
+		projects = append(projects, current)
+	}
+
+	path, err := projectsFile()
+	if err != nil {
+		return err
+	}
+
+	if err := ensureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(strings.Join(projects, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,439 @@
+/*
+Copyright © 2024 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Names of the registry container on the host and of the objects of the
+// in-cluster registry.
+const (
+	registryName      = "k8s-dev-registry"
+	registryNamespace = "k8s-dev-registry"
+)
+
+var registryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "Run a local container registry mirrored by the nodes of the development environment",
+	Long: `Run a local container registry, either as a container on the host or as a deployment in the
+cluster, and configure k3s to mirror it. Images are pushed to 'localhost:<port>' and the nodes pull
+them from the registry, so charts can reference the same image names on the host and in the cluster.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		ensureRootDirectory()
+
+		if isMinikubeEnv() {
+			cobra.CheckErr(errors.New("the registry is only supported for Vagrant, use 'minikube addons enable registry' instead"))
+		}
+
+		cobra.CheckErr(ensureEnvironment())
+	},
+}
+
+var registryEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Run the local registry and configure the nodes to mirror it",
+	Long: `Run the local registry on the host or in the cluster, write the matching custom_registries_yaml
+into group_vars/k3s_cluster.yml and re-apply the k3s_custom_registries role to every node.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		mode, _ := cmd.Flags().GetString("mode")
+		port, _ := cmd.Flags().GetInt("port")
+		image, _ := cmd.Flags().GetString("image")
+
+		var endpoint string
+		var err error
+
+		switch mode {
+		case "host":
+			engine, _ := cmd.Flags().GetString("engine")
+
+			endpoint, err = runHostRegistry(engine, image, port)
+		case "cluster":
+			nodePort, _ := cmd.Flags().GetInt("node-port")
+
+			endpoint, err = runClusterRegistry(image, nodePort)
+		}
+
+		cobra.CheckErr(err)
+
+		printSubMessage(fmt.Sprintf("mirroring 'localhost:%d' to '%s'", port, endpoint))
+
+		vars := makePath("group_vars", "k3s_cluster.yml")
+
+		original, err := readFile(vars)
+		cobra.CheckErr(err)
+
+		cobra.CheckErr(writeRegistryVars(port, endpoint))
+
+		printMessage("Configuring the nodes to mirror the registry...")
+
+		if err := applyRegistryPlaybook(cmd); err != nil {
+			// The nodes were not configured, so the project is left as it was.
+			cobra.CheckErr(errors.Join(err, os.WriteFile(vars, []byte(original), 0644)))
+		}
+
+		settings, err := loadSettings()
+		cobra.CheckErr(err)
+
+		settings.Registry = registrySettings{
+			Mode:     mode,
+			Port:     port,
+			Endpoint: endpoint,
+		}
+
+		cobra.CheckErr(saveSettings(settings))
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		mode, _ := cmd.Flags().GetString("mode")
+
+		if mode != "host" && mode != "cluster" {
+			cobra.CheckErr(fmt.Errorf("'%s' is invalid for mode. Valid options: host, cluster", mode))
+		}
+	},
+}
+
+var registryPushCmd = &cobra.Command{
+	Use:   "push <image>...",
+	Short: "Tag local images and push them to the local registry",
+	Long: `Tag local images as 'localhost:<port>/<name>' and push them to the local registry, so they can be
+used by the nodes of the development environment.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(pushImages(cmd, args))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(registryCmd)
+
+	registryCmd.AddCommand(registryEnableCmd)
+	registryCmd.AddCommand(registryPushCmd)
+
+	registryCmd.PersistentFlags().String("engine", "docker", "container engine used to run the registry and push images")
+
+	registryEnableCmd.Flags().String("mode", "host", "where to run the registry (host or cluster)")
+	registryEnableCmd.Flags().Int("port", 5000, "port of the registry on the host")
+	registryEnableCmd.Flags().Int("node-port", 30500, "node port of the registry when running in the cluster")
+	registryEnableCmd.Flags().String("image", "registry:2", "image of the registry")
+}
+
+func applyRegistryPlaybook(cmd *cobra.Command) error {
+	playbook := makePath(".tmp", "registry.yml")
+
+	if err := ensureDir(".tmp"); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(playbook, []byte(registryPlaybook), 0644); err != nil {
+		return err
+	}
+
+	err := runPlaybook(cmd, nil, playbook)
+
+	return errors.Join(err, removeFile(playbook))
+}
+
+// pushImages tags images and pushes them to the local registry. In cluster
+// mode the registry is reached through a port forward that is stopped before
+// returning, so a failed push doesn't leave the port bound.
+func pushImages(cmd *cobra.Command, images []string) error {
+	settings, err := loadSettings()
+	if err != nil {
+		return err
+	}
+
+	registry := settings.Registry
+
+	if len(registry.Mode) == 0 {
+		return errors.New("the registry is not enabled, run 'k8s-dev registry enable' first")
+	}
+
+	if registry.Mode == "cluster" {
+		forward := exec.Command("kubectl", kubectlParams("port-forward",
+			"--namespace", registryNamespace,
+			"service/registry",
+			fmt.Sprintf("%d:5000", registry.Port))...)
+
+		if err := forward.Start(); err != nil {
+			return err
+		}
+
+		defer func() {
+			_ = forward.Process.Kill()
+			_ = forward.Wait()
+		}()
+
+		if err := waitForPort(fmt.Sprintf("localhost:%d", registry.Port), 30*time.Second); err != nil {
+			return err
+		}
+	}
+
+	engine, _ := cmd.Flags().GetString("engine")
+
+	for _, image := range images {
+		target := fmt.Sprintf("localhost:%d/%s", registry.Port, registryImageName(image))
+
+		printMessage(fmt.Sprintf("Pushing '%s' as '%s'...", image, target))
+
+		if _, err := executeCommandOutput(engine, "tag", image, target); err != nil {
+			return err
+		}
+
+		if err := executeExternalProgram(engine, "push", target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runHostRegistry starts the registry container on the host, creating it the
+// first time, and returns the endpoint the nodes reach it at.
+func runHostRegistry(engine, image string, port int) (string, error) {
+	status, err := executeCommandOutput(engine, "ps", "--all",
+		"--filter", "name=^"+registryName+"$",
+		"--format", "{{.State}}")
+
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.TrimSpace(status) {
+	case "":
+		printMessage(fmt.Sprintf("Starting the registry on port %d...", port))
+
+		_, err = executeCommandOutput(engine, "run", "--detach",
+			"--restart", "always",
+			"--name", registryName,
+			"--publish", fmt.Sprintf("%d:5000", port),
+			image)
+	case "running":
+		printSubMessage(fmt.Sprintf("the '%s' container is already running", registryName))
+	default:
+		printMessage("Starting the registry...")
+
+		_, err = executeCommandOutput(engine, "start", registryName)
+	}
+
+	return fmt.Sprintf("http://%s:%d", hostAddress, port), err
+}
+
+// runClusterRegistry deploys the registry in the cluster and returns the
+// endpoint the nodes reach it at through its node port. The images are stored
+// on a volume of the default storage class so they survive a restart.
+func runClusterRegistry(image string, nodePort int) (string, error) {
+	inv, err := readInventory("hosts.ini")
+	if err != nil {
+		return "", err
+	}
+
+	masters := inv.Groups["master"]
+
+	if len(masters) == 0 {
+		return "", errors.New("the inventory doesn't contain any master nodes")
+	}
+
+	printMessage(fmt.Sprintf("Deploying the registry to the '%s' namespace...", registryNamespace))
+
+	manifest := makePath(".tmp", "registry.yaml")
+
+	if err := ensureDir(".tmp"); err != nil {
+		return "", err
+	}
+
+	content := fmt.Sprintf(registryManifest, registryNamespace, image, nodePort)
+
+	if err := os.WriteFile(manifest, []byte(content), 0644); err != nil {
+		return "", err
+	}
+
+	_, err = kubectl("apply", "--filename", manifest)
+
+	if rmErr := removeFile(manifest); err == nil {
+		err = rmErr
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := kubectl("rollout", "status", "deployment/registry", "--namespace", registryNamespace, "--timeout", "5m"); err != nil {
+		return "", err
+	}
+
+	host := inv.HostVar(masters[0], "ansible_host")
+
+	if len(host) == 0 {
+		host = masters[0]
+	}
+
+	return fmt.Sprintf("http://%s:%d", host, nodePort), nil
+}
+
+// writeRegistryVars enables the custom registries in the cluster variables and
+// mirrors localhost:port to endpoint.
+func writeRegistryVars(port int, endpoint string) error {
+	path := makePath("group_vars", "k3s_cluster.yml")
+
+	content, err := readFile(path)
+	if err != nil {
+		return err
+	}
+
+	registries := fmt.Sprintf(`custom_registries_yaml: |
+  mirrors:
+    "localhost:%d":
+      endpoint:
+        - "%s"
+`, port, endpoint)
+
+	enabled := regexp.MustCompile(`(?m)^custom_registries:.*$`)
+
+	if enabled.MatchString(content) {
+		content = enabled.ReplaceAllString(content, "custom_registries: true")
+	} else {
+		content = strings.TrimRight(content, "\n") + "\ncustom_registries: true\n"
+	}
+
+	yaml := regexp.MustCompile(`(?m)^custom_registries_yaml:.*\n(?:[ \t]+.*\n)*`)
+
+	if yaml.MatchString(content) {
+		content = yaml.ReplaceAllLiteralString(content, registries)
+	} else {
+		content = strings.TrimRight(content, "\n") + "\n" + registries
+	}
+
+	printSubMessage(fmt.Sprintf("updating '%s'", path))
+
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// registryImageName removes the registry, if any, from the name of an image.
+func registryImageName(image string) string {
+	first, rest, found := strings.Cut(image, "/")
+
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return rest
+	}
+
+	return image
+}
+
+func waitForPort(address string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+
+		if err == nil {
+			return conn.Close()
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return fmt.Errorf("timed out waiting for '%s'", address)
+}
+
+const registryPlaybook = `---
+- name: Configure the local registry mirror
+  hosts: k3s_cluster
+  gather_facts: true
+  become: true
+  serial: 1
+
+  roles:
+    - role: techno_tim.k3s_ansible.k3s_custom_registries
+
+  post_tasks:
+    - name: Restart k3s to load the registry mirror
+      ansible.builtin.systemd:
+        name: "{{ 'k3s' if inventory_hostname in groups['master'] else 'k3s-node' }}"
+        state: restarted
+`
+
+const registryManifest = `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: %[1]s
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: registry
+  namespace: %[1]s
+spec:
+  accessModes:
+    - ReadWriteOnce
+  resources:
+    requests:
+      storage: 10Gi
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: registry
+  namespace: %[1]s
+spec:
+  replicas: 1
+  strategy:
+    type: Recreate
+  selector:
+    matchLabels:
+      app: registry
+  template:
+    metadata:
+      labels:
+        app: registry
+    spec:
+      containers:
+        - name: registry
+          image: %[2]s
+          ports:
+            - containerPort: 5000
+          volumeMounts:
+            - name: data
+              mountPath: /var/lib/registry
+      volumes:
+        - name: data
+          persistentVolumeClaim:
+            claimName: registry
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: registry
+  namespace: %[1]s
+spec:
+  type: NodePort
+  selector:
+    app: registry
+  ports:
+    - port: 5000
+      targetPort: 5000
+      nodePort: %[3]d
+`
@@ -0,0 +1,470 @@
+/*
+Copyright © 2024 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// deployedRelease is a release as listed by 'helm list'.
+type deployedRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  string `json:"revision"`
+	Status    string `json:"status"`
+	Chart     string `json:"chart"`
+}
+
+var releasesCmd = &cobra.Command{
+	Use:   "releases",
+	Short: "Reconcile the Helm releases declared in the project settings",
+	Long: `Reconcile the Helm releases declared in the releases section of k8s-dev.yml with the Kubernetes
+development environment. Releases are installed in the order given by their needs, and removed in the
+reverse order. A release with 'installed: false' is uninstalled by sync.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		ensureRootDirectory()
+		cobra.CheckErr(ensureEnvironment())
+	},
+}
+
+var releasesSyncCmd = &cobra.Command{
+	Use:   "sync [release...]",
+	Short: "Install, upgrade or uninstall the declared Helm releases",
+	Long:  "Install, upgrade or uninstall the declared Helm releases, along with the releases they need",
+	Run: func(cmd *cobra.Command, args []string) {
+		settings, releases, err := declaredReleases(args, true)
+		cobra.CheckErr(err)
+
+		deployed, err := deployedReleases()
+		cobra.CheckErr(err)
+
+		cobra.CheckErr(addRepositories(settings.Repositories))
+
+		timeout, _ := cmd.Flags().GetString("timeout")
+
+		var removed []helmRelease
+
+		for _, r := range releases {
+			if !r.installed() {
+				removed = append(removed, r)
+			}
+		}
+
+		cobra.CheckErr(uninstallReleases(removed, deployed))
+
+		for _, r := range releases {
+			if !r.installed() {
+				continue
+			}
+
+			printMessage(fmt.Sprintf("Syncing '%s' in '%s'...", r.Name, r.namespace()))
+
+			cobra.CheckErr(r.dependencies())
+
+			params := append(r.params("upgrade", "--install"),
+				"--create-namespace",
+				"--wait",
+				"--timeout", timeout)
+
+			cobra.CheckErr(executeExternalProgram("helm", helmParams(params...)...))
+		}
+	},
+}
+
+var releasesDiffCmd = &cobra.Command{
+	Use:   "diff [release...]",
+	Short: "Show the changes sync would make to the declared Helm releases",
+	Long:  "Show the changes sync would make to the declared Helm releases",
+	Run: func(cmd *cobra.Command, args []string) {
+		settings, releases, err := declaredReleases(args, true)
+		cobra.CheckErr(err)
+
+		deployed, err := deployedReleases()
+		cobra.CheckErr(err)
+
+		cobra.CheckErr(addRepositories(settings.Repositories))
+
+		for _, r := range releases {
+			_, exists := deployed[r.key()]
+
+			if !r.installed() {
+				if exists {
+					printSubMessage(fmt.Sprintf("'%s' will be uninstalled", r.Name))
+				}
+
+				continue
+			}
+
+			cobra.CheckErr(r.dependencies())
+
+			// Hooks are not part of the manifest of a deployed release.
+			output, err := executeCommandOutput("helm", r.params("template", "--no-hooks")...)
+			cobra.CheckErr(err)
+
+			desired, err := normalizeManifests(output)
+			cobra.CheckErr(err)
+
+			var current []manifestDocument
+
+			if exists {
+				output, err := executeCommandOutput("helm", helmParams("get", "manifest", r.Name, "--namespace", r.namespace())...)
+				cobra.CheckErr(err)
+
+				current, err = normalizeManifests(output)
+				cobra.CheckErr(err)
+			}
+
+			lines := diffManifests(current, desired)
+
+			switch {
+			case !exists:
+				printSubMessage(fmt.Sprintf("'%s' will be installed", r.Name))
+			case len(lines) == 0:
+				printSubMessage(fmt.Sprintf("'%s' is up to date", r.Name))
+
+				continue
+			default:
+				printSubMessage(fmt.Sprintf("'%s' will be upgraded", r.Name))
+			}
+
+			for _, line := range lines {
+				fmt.Println(line)
+			}
+		}
+	},
+}
+
+var releasesStatusCmd = &cobra.Command{
+	Use:   "status [release...]",
+	Short: "Compare the declared Helm releases with the deployed releases",
+	Long:  "Compare the declared Helm releases with the deployed releases",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, releases, err := declaredReleases(args, false)
+		cobra.CheckErr(err)
+
+		deployed, err := deployedReleases()
+		cobra.CheckErr(err)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintln(w, "RELEASE\tNAMESPACE\tCHART\tVERSION\tDEPLOYED\tSTATUS")
+
+		for _, r := range releases {
+			version := r.Version
+
+			if len(version) == 0 {
+				version = "-"
+			}
+
+			chart := "-"
+			status := "missing"
+
+			if d, ok := deployed[r.key()]; ok {
+				chart = d.Chart
+				status = d.Status
+
+				if len(r.Version) > 0 && !strings.HasSuffix(d.Chart, "-"+r.Version) {
+					status = "outdated"
+				}
+			}
+
+			if !r.installed() {
+				status = "removed"
+
+				if chart != "-" {
+					status = "pending removal"
+				}
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, r.namespace(), r.Chart, version, chart, status)
+		}
+
+		cobra.CheckErr(w.Flush())
+	},
+}
+
+var releasesDestroyCmd = &cobra.Command{
+	Use:   "destroy [release...]",
+	Short: "Uninstall the declared Helm releases",
+	Long:  "Uninstall the declared Helm releases, unless a release that remains installed needs them",
+	Run: func(cmd *cobra.Command, args []string) {
+		settings, releases, err := declaredReleases(args, false)
+		cobra.CheckErr(err)
+
+		deployed, err := deployedReleases()
+		cobra.CheckErr(err)
+
+		cobra.CheckErr(neededReleases(settings.Releases, releases, deployed))
+
+		force, _ := cmd.Flags().GetBool("force")
+
+		if !force {
+			force = askForConfirmation("Are you sure you want to uninstall the declared releases?")
+		}
+
+		if !force {
+			return
+		}
+
+		cobra.CheckErr(uninstallReleases(releases, deployed))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(releasesCmd)
+
+	releasesCmd.AddCommand(releasesSyncCmd)
+	releasesCmd.AddCommand(releasesDiffCmd)
+	releasesCmd.AddCommand(releasesStatusCmd)
+	releasesCmd.AddCommand(releasesDestroyCmd)
+
+	releasesSyncCmd.Flags().String("timeout", "5m", "how long to wait for each release to be ready")
+	releasesDestroyCmd.Flags().BoolP("force", "f", false, "uninstall without confirmation")
+}
+
+// uninstallReleases uninstalls the deployed releases of releases, which are in
+// dependency order, in reverse so releases are removed before their needs.
+func uninstallReleases(releases []helmRelease, deployed map[string]deployedRelease) error {
+	for i := len(releases) - 1; i >= 0; i-- {
+		if _, ok := deployed[releases[i].key()]; ok {
+			if err := releases[i].uninstall(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r helmRelease) namespace() string {
+	if len(r.Namespace) > 0 {
+		return r.Namespace
+	}
+
+	return r.Name
+}
+
+func (r helmRelease) installed() bool {
+	return r.Installed == nil || *r.Installed
+}
+
+func (r helmRelease) key() string {
+	return r.namespace() + "/" + r.Name
+}
+
+func (r helmRelease) params(command ...string) []string {
+	params := append(command, r.Name, r.Chart, "--namespace", r.namespace())
+
+	if len(r.Version) > 0 {
+		params = append(params, "--version", r.Version)
+	}
+
+	for _, v := range r.Values {
+		params = append(params, "--values", v)
+	}
+
+	for _, s := range r.Set {
+		params = append(params, "--set", s)
+	}
+
+	return params
+}
+
+// dependencies builds the dependencies of a release of a local chart.
+func (r helmRelease) dependencies() error {
+	if !dirExists(r.Chart) {
+		return nil
+	}
+
+	chart, err := readChart(r.Chart)
+	if err != nil {
+		return err
+	}
+
+	return chartDependencies(r.Chart, chart)
+}
+
+func (r helmRelease) uninstall() error {
+	printMessage(fmt.Sprintf("Uninstalling '%s' from '%s'...", r.Name, r.namespace()))
+
+	return executeExternalProgram("helm", helmParams("uninstall", r.Name, "--namespace", r.namespace(), "--wait")...)
+}
+
+// declaredReleases returns the releases of the project settings, limited to
+// names when given, in the order they need to be installed. With needs, the
+// releases needed by names, directly or not, are returned along with them.
+func declaredReleases(names []string, needs bool) (projectSettings, []helmRelease, error) {
+	settings, err := loadSettings()
+	if err != nil {
+		return settings, nil, err
+	}
+
+	if len(settings.Releases) == 0 {
+		return settings, nil, fmt.Errorf("no releases are declared in '%s'", settingsFile)
+	}
+
+	releases, err := orderReleases(settings.Releases)
+	if err != nil {
+		return settings, nil, err
+	}
+
+	for _, name := range names {
+		if !slices.ContainsFunc(releases, func(r helmRelease) bool { return r.Name == name }) {
+			return settings, nil, fmt.Errorf("'%s' is not a declared release", name)
+		}
+	}
+
+	selected := slices.Clone(names)
+
+	if needs {
+		for i := 0; i < len(selected); i++ {
+			r := releases[slices.IndexFunc(releases, func(r helmRelease) bool { return r.Name == selected[i] })]
+
+			for _, need := range r.Needs {
+				if !slices.Contains(selected, need) {
+					selected = append(selected, need)
+				}
+			}
+		}
+	}
+
+	if len(selected) > 0 {
+		releases = slices.DeleteFunc(releases, func(r helmRelease) bool {
+			return !slices.Contains(selected, r.Name)
+		})
+	}
+
+	return settings, releases, nil
+}
+
+// neededReleases returns an error when one of releases is needed by a deployed
+// release that isn't one of them.
+func neededReleases(declared, releases []helmRelease, deployed map[string]deployedRelease) error {
+	for _, d := range declared {
+		if slices.ContainsFunc(releases, func(r helmRelease) bool { return r.Name == d.Name }) {
+			continue
+		}
+
+		if _, ok := deployed[d.key()]; !ok {
+			continue
+		}
+
+		for _, r := range releases {
+			if slices.Contains(d.Needs, r.Name) {
+				return fmt.Errorf("'%s' can't be uninstalled while '%s' needs it", r.Name, d.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// orderReleases sorts releases so every release comes after the releases it
+// needs.
+func orderReleases(releases []helmRelease) ([]helmRelease, error) {
+	var ordered []helmRelease
+
+	state := map[string]int{}
+
+	var visit func(r helmRelease) error
+
+	visit = func(r helmRelease) error {
+		switch state[r.Name] {
+		case 1:
+			return fmt.Errorf("the needs of release '%s' are circular", r.Name)
+		case 2:
+			return nil
+		}
+
+		state[r.Name] = 1
+
+		for _, need := range r.Needs {
+			i := slices.IndexFunc(releases, func(n helmRelease) bool { return n.Name == need })
+
+			if i < 0 {
+				return fmt.Errorf("release '%s' needs '%s' which is not declared", r.Name, need)
+			}
+
+			if err := visit(releases[i]); err != nil {
+				return err
+			}
+		}
+
+		state[r.Name] = 2
+		ordered = append(ordered, r)
+
+		return nil
+	}
+
+	for _, r := range releases {
+		if len(r.Name) == 0 || len(r.Chart) == 0 {
+			return nil, errors.New("every release needs a name and a chart")
+		}
+
+		if err := visit(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+func addRepositories(repositories []helmRepository) error {
+	if len(repositories) == 0 {
+		return nil
+	}
+
+	for _, r := range repositories {
+		printSubMessage(fmt.Sprintf("adding repository '%s'", r.Name))
+
+		if _, err := executeCommandOutput("helm", "repo", "add", r.Name, r.URL, "--force-update"); err != nil {
+			return err
+		}
+	}
+
+	_, err := executeCommandOutput("helm", "repo", "update")
+
+	return err
+}
+
+func deployedReleases() (map[string]deployedRelease, error) {
+	releases := map[string]deployedRelease{}
+
+	output, err := executeCommandOutput("helm", helmParams("list", "--all-namespaces", "--all", "--output", "json")...)
+	if err != nil {
+		return releases, err
+	}
+
+	var list []deployedRelease
+
+	if err := json.Unmarshal([]byte(output), &list); err != nil {
+		return releases, err
+	}
+
+	for _, r := range list {
+		releases[r.Namespace+"/"+r.Name] = r
+	}
+
+	return releases, nil
+}
@@ -0,0 +1,393 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resultsCallback is an Ansible callback plugin that records every task
+// result to the file named by K8S_DEV_RESULTS when the playbook finishes.
+const resultsCallback = `from __future__ import annotations
+
+import json
+import os
+import time
+
+from ansible.plugins.callback import CallbackBase
+
+DOCUMENTATION = """
+name: k8s_dev
+type: aggregate
+short_description: Records task results for k8s-dev test reports
+description:
+  - Writes every task result to the file named by the K8S_DEV_RESULTS environment variable.
+"""
+
+
+class CallbackModule(CallbackBase):
+    CALLBACK_VERSION = 2.0
+    CALLBACK_TYPE = "aggregate"
+    CALLBACK_NAME = "k8s_dev"
+    CALLBACK_NEEDS_ENABLED = True
+
+    def __init__(self):
+        super().__init__()
+        self.path = os.environ.get("K8S_DEV_RESULTS")
+        self.play = ""
+        self.results = []
+        self.started = {}
+
+    def v2_playbook_on_play_start(self, play):
+        self.play = play.get_name()
+
+    def v2_playbook_on_task_start(self, task, is_conditional):
+        self.started[task._uuid] = time.time()
+
+    def v2_playbook_on_handler_task_start(self, task):
+        self.started[task._uuid] = time.time()
+
+    def _record(self, result, status):
+        task = result._task
+        data = result._result
+        message = ""
+
+        if status in ("failed", "ignored", "unreachable"):
+            message = str(data.get("msg") or data.get("stderr") or "")
+        elif status == "skipped":
+            message = str(data.get("skip_reason") or data.get("msg") or "")
+
+        self.results.append({
+            "play": self.play,
+            "task": task.get_name(),
+            "action": task.action,
+            "host": result._host.get_name(),
+            "status": status,
+            "changed": bool(data.get("changed", False)),
+            "duration": time.time() - self.started.get(task._uuid, time.time()),
+            "message": message,
+            "path": task.get_path() or "",
+            "role": task._role.get_name() if task._role else "",
+        })
+
+    def v2_runner_on_ok(self, result):
+        self._record(result, "ok")
+
+    def v2_runner_on_failed(self, result, ignore_errors=False):
+        self._record(result, "ignored" if ignore_errors else "failed")
+
+    def v2_runner_on_skipped(self, result):
+        self._record(result, "skipped")
+
+    def v2_runner_on_unreachable(self, result):
+        self._record(result, "unreachable")
+
+    def v2_playbook_on_stats(self, stats):
+        if self.path:
+            with open(self.path, "w", encoding="utf-8") as f:
+                json.dump(self.results, f)
+`
+
+type taskResult struct {
+	Play     string  `json:"play"`
+	Task     string  `json:"task"`
+	Action   string  `json:"action"`
+	Host     string  `json:"host"`
+	Status   string  `json:"status"`
+	Changed  bool    `json:"changed"`
+	Duration float64 `json:"duration"`
+	Message  string  `json:"message,omitempty"`
+	Path     string  `json:"path,omitempty"`
+	Role     string  `json:"role,omitempty"`
+}
+
+func (r taskResult) Failed() bool {
+	return r.Status == "failed" || r.Status == "unreachable"
+}
+
+func readTaskResults(path string) ([]taskResult, error) {
+	var results []taskResult
+
+	if !fileExists(path) {
+		return results, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return results, err
+	}
+
+	err = json.Unmarshal(content, &results)
+
+	return results, err
+}
+
+// ansibleCallbacks returns the callback plugins and callback plugin paths of
+// the project, so the results callback can be added to them rather than
+// replacing them.
+var ansibleCallbacks = sync.OnceValues(func() (ansibleCallbackConfig, error) {
+	var config ansibleCallbackConfig
+
+	output, err := executeCommandOutput("ansible-config", "dump")
+	if err != nil {
+		return config, err
+	}
+
+	config.Enabled = ansibleListSetting(output, "CALLBACKS_ENABLED", "DEFAULT_CALLBACK_WHITELIST")
+	config.Paths = ansibleListSetting(output, "DEFAULT_CALLBACK_PLUGIN_PATH")
+
+	return config, nil
+})
+
+type ansibleCallbackConfig struct {
+	Enabled []string
+	Paths   []string
+}
+
+// ansibleListSetting returns the value of the first of names that is a list in
+// the output of 'ansible-config dump'.
+func ansibleListSetting(output string, names ...string) []string {
+	for _, name := range names {
+		setting := regexp.MustCompile(`(?m)^` + name + `\(.*\) = \[(.*)\]\s*$`)
+
+		if m := setting.FindStringSubmatch(output); m != nil {
+			var values []string
+
+			for _, v := range strings.Split(m[1], ",") {
+				if v = strings.Trim(strings.TrimSpace(v), `'"`); len(v) > 0 {
+					values = append(values, v)
+				}
+			}
+
+			return values
+		}
+	}
+
+	return nil
+}
+
+type jsonReport struct {
+	Passed   bool            `json:"passed"`
+	Duration float64         `json:"duration"`
+	Results  []jsonRunReport `json:"results"`
+}
+
+type jsonRunReport struct {
+	Role     string            `json:"role"`
+	Scenario string            `json:"scenario,omitempty"`
+	Passed   bool              `json:"passed"`
+	Duration float64           `json:"duration"`
+	Phases   []jsonPhaseReport `json:"phases"`
+}
+
+type jsonPhaseReport struct {
+	Name     string       `json:"name"`
+	Status   string       `json:"status"`
+	Message  string       `json:"message,omitempty"`
+	Duration float64      `json:"duration"`
+	Tasks    int          `json:"tasks"`
+	Changed  int          `json:"changed"`
+	Failed   int          `json:"failed"`
+	Results  []taskResult `json:"results,omitempty"`
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func writeTestReports(dir string, results []testResult) error {
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(newJSONReport(results), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(makePath(dir, "results.json"), content, 0644); err != nil {
+		return err
+	}
+
+	content, err = xml.MarshalIndent(newJUnitReport(results), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	content = append([]byte(xml.Header), content...)
+
+	if err := os.WriteFile(makePath(dir, "junit.xml"), content, 0644); err != nil {
+		return err
+	}
+
+	printSubMessage(fmt.Sprintf("test reports written to '%s'", dir))
+
+	return nil
+}
+
+func newJSONReport(results []testResult) jsonReport {
+	report := jsonReport{Passed: true}
+
+	for _, r := range results {
+		run := jsonRunReport{
+			Role:     r.Role,
+			Scenario: r.Scenario,
+			Passed:   r.Passed(),
+			Duration: r.Duration.Seconds(),
+		}
+
+		for _, p := range r.Phases {
+			phase := jsonPhaseReport{
+				Name:     p.Name,
+				Status:   p.Status,
+				Message:  p.Message,
+				Duration: p.Duration.Seconds(),
+				Tasks:    len(p.Tasks),
+				Results:  p.Tasks,
+			}
+
+			for _, t := range p.Tasks {
+				if t.Changed {
+					phase.Changed++
+				}
+
+				if t.Failed() {
+					phase.Failed++
+				}
+			}
+
+			run.Phases = append(run.Phases, phase)
+		}
+
+		report.Passed = report.Passed && run.Passed
+		report.Duration += run.Duration
+		report.Results = append(report.Results, run)
+	}
+
+	return report
+}
+
+// newJUnitReport creates a test suite for every role test with a test case
+// for each phase and each task result of that phase.
+func newJUnitReport(results []testResult) junitTestSuites {
+	report := junitTestSuites{Name: "k8s-dev"}
+	total := time.Duration(0)
+
+	for _, r := range results {
+		suite := junitTestSuite{
+			Name:      r.Name(),
+			Time:      junitTime(r.Duration.Seconds()),
+			Timestamp: r.Started.Format("2006-01-02T15:04:05"),
+		}
+
+		for _, p := range r.Phases {
+			phase := junitTestCase{
+				Name:      p.Name,
+				ClassName: r.Name(),
+				Time:      junitTime(p.Duration.Seconds()),
+			}
+
+			switch p.Status {
+			case phaseFailed:
+				phase.Failure = &junitMessage{Message: p.Message}
+			case phaseSkipped:
+				phase.Skipped = &junitMessage{Message: p.Message}
+			}
+
+			suite.Cases = append(suite.Cases, phase)
+
+			for _, t := range p.Tasks {
+				task := junitTestCase{
+					Name:      fmt.Sprintf("%s: %s [%s]", p.Name, t.Task, t.Host),
+					ClassName: fmt.Sprintf("%s.%s", r.Name(), p.Name),
+					Time:      junitTime(t.Duration),
+				}
+
+				switch {
+				case t.Failed():
+					task.Failure = &junitMessage{Message: t.Message, Text: t.Path}
+				case t.Status == "skipped":
+					task.Skipped = &junitMessage{Message: t.Message}
+				}
+
+				suite.Cases = append(suite.Cases, task)
+			}
+		}
+
+		for _, c := range suite.Cases {
+			if c.Failure != nil {
+				suite.Failures++
+			}
+
+			if c.Skipped != nil {
+				suite.Skipped++
+			}
+		}
+
+		suite.Tests = len(suite.Cases)
+
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+		report.Suites = append(report.Suites, suite)
+
+		total += r.Duration
+	}
+
+	report.Time = junitTime(total.Seconds())
+
+	return report
+}
+
+func junitTime(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
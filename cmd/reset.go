@@ -36,9 +36,9 @@ var resetCmd = &cobra.Command{
 
 			cobra.CheckErr(vagrantUp("", provision))
 
-			cobra.CheckErr(executeExternalProgram("ansible-playbook", "playbooks/init.yml"))
+			cobra.CheckErr(runPlaybook(cmd, nil, "playbooks/init.yml"))
 		} else {
-			cobra.CheckErr(executeExternalProgram("ansible-playbook", "playbooks/reset.yml"))
+			cobra.CheckErr(runPlaybook(cmd, nil, "playbooks/reset.yml"))
 		}
 
 		deploy, _ := cmd.Flags().GetBool("deploy")
@@ -75,4 +75,6 @@ func init() {
 	resetCmd.Flags().Bool("recreate", false, "Recreate the vagrant hosts")
 	resetCmd.Flags().BoolP("nodes", "n", true, "Show nodes of development environment")
 	resetCmd.Flags().BoolP("deploy", "d", false, "deploy the Kubernetes cluster")
+
+	addAnsibleFlags(resetCmd)
 }
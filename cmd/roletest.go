@@ -0,0 +1,1002 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testNamespaceLabel is applied to the namespaces created for isolated tests.
+const testNamespaceLabel = "k8s-dev/test"
+
+const (
+	phasePassed  = "passed"
+	phaseFailed  = "failed"
+	phaseSkipped = "skipped"
+)
+
+type testOptions struct {
+	Converge      bool
+	Assert        bool
+	AssertTimeout time.Duration
+	Idempotence   bool
+	Verify        bool
+	Hosts         string
+	Become        bool
+	Isolate       bool
+	Quiet         bool
+	Tags          string
+	Check         bool
+	Diff          bool
+	Step          bool
+	Verbose       bool
+}
+
+type testPlay struct {
+	Name        string                 `yaml:"name,omitempty"`
+	Hosts       string                 `yaml:"hosts"`
+	GatherFacts bool                   `yaml:"gather_facts"`
+	Become      bool                   `yaml:"become,omitempty"`
+	VarsFiles   []string               `yaml:"vars_files,omitempty"`
+	Vars        map[string]interface{} `yaml:"vars,omitempty"`
+	Roles       []testPlayRole         `yaml:"roles,omitempty"`
+	Tasks       []map[string]string    `yaml:"tasks,omitempty"`
+}
+
+type testPlayRole struct {
+	Role string `yaml:"role"`
+}
+
+type phaseResult struct {
+	Name     string
+	Status   string
+	Message  string
+	Duration time.Duration
+	Tasks    []taskResult
+}
+
+type testResult struct {
+	Role     string
+	Scenario string
+	Phases   []phaseResult
+	Started  time.Time
+	Duration time.Duration
+	Log      string
+}
+
+// skipPhase is returned by a phase that has nothing to do.
+type skipPhase string
+
+func (s skipPhase) Error() string {
+	return string(s)
+}
+
+type roleTest struct {
+	Role      string
+	Playbook  string
+	Scenario  testScenario
+	Options   testOptions
+	Dir       string
+	Namespace string
+
+	// tasks collects the task results of the playbooks run by the current
+	// phase.
+	tasks []taskResult
+	runs  int
+	log   io.Writer
+}
+
+func newRoleTest(role string, scenario testScenario, opts testOptions) *roleTest {
+	name := role
+
+	if len(scenario.Name) > 0 {
+		name = role + "-" + scenario.Name
+	}
+
+	t := &roleTest{
+		Role:     role,
+		Scenario: scenario,
+		Options:  opts,
+		Dir:      testWorkDir(name),
+	}
+
+	if opts.Isolate {
+		t.Namespace = testNamespace(name)
+	}
+
+	return t
+}
+
+// newPlaybookTest creates a test that converges an existing playbook rather
+// than a play generated for a role.
+func newPlaybookTest(playbook string, opts testOptions) *roleTest {
+	t := newRoleTest(playbook, testScenario{}, opts)
+	t.Playbook = playbook
+
+	return t
+}
+
+func isPlaybookFile(name string) bool {
+	ext := filepath.Ext(name)
+
+	return fileExists(name) && (ext == ".yml" || ext == ".yaml")
+}
+
+func (t *roleTest) Name() string {
+	if len(t.Scenario.Name) > 0 {
+		return fmt.Sprintf("%s (%s)", t.Role, t.Scenario.Name)
+	}
+
+	return t.Role
+}
+
+func (r testResult) Name() string {
+	if len(r.Scenario) > 0 {
+		return fmt.Sprintf("%s (%s)", r.Role, r.Scenario)
+	}
+
+	return r.Role
+}
+
+func (r testResult) Passed() bool {
+	return len(r.failed()) == 0
+}
+
+func (r testResult) failed() string {
+	for _, p := range r.Phases {
+		if p.Status == phaseFailed {
+			return p.Name
+		}
+	}
+
+	return ""
+}
+
+// run executes a phase unless an earlier phase has failed.
+func (t *roleTest) run(result *testResult, name string, run func() error) {
+	if failed := result.failed(); len(failed) > 0 {
+		result.Phases = append(result.Phases, phaseResult{
+			Name:    name,
+			Status:  phaseSkipped,
+			Message: fmt.Sprintf("%s failed", failed),
+		})
+
+		return
+	}
+
+	result.Phases = append(result.Phases, t.phase(name, run))
+}
+
+func (t *roleTest) phase(name string, run func() error) phaseResult {
+	t.tasks = nil
+
+	phase := runPhase(name, run)
+	phase.Tasks = t.tasks
+
+	return phase
+}
+
+func (t *roleTest) Run() testResult {
+	start := time.Now()
+	result := testResult{
+		Role:     t.Role,
+		Scenario: t.Scenario.Name,
+		Started:  start,
+	}
+
+	release, err := t.setup()
+	if err != nil {
+		result.Phases = append(result.Phases, phaseResult{
+			Name:    "converge",
+			Status:  phaseFailed,
+			Message: err.Error(),
+		})
+
+		return result
+	}
+
+	defer release()
+
+	if len(t.Scenario.Prepare) > 0 {
+		t.run(&result, "prepare", t.prepare)
+	}
+
+	if t.Options.Converge {
+		t.run(&result, "converge", t.runConverge)
+	}
+
+	if t.Options.Assert {
+		t.run(&result, "assert", t.assert)
+	}
+
+	if t.Options.Idempotence {
+		t.run(&result, "idempotence", t.idempotence)
+	}
+
+	if t.Options.Verify {
+		t.run(&result, "verify", t.verify)
+	}
+
+	if len(t.Scenario.Cleanup) > 0 {
+		// Cleanup runs even when an earlier phase has failed.
+		result.Phases = append(result.Phases, t.phase("cleanup", t.cleanup))
+	}
+
+	result.Duration = time.Since(start)
+
+	if t.Options.Quiet {
+		result.Log, _ = readFile(makePath(t.Dir, "ansible.log"))
+	}
+
+	return result
+}
+
+// setup prepares the working directory, generated play and namespace of the
+// test. Quiet tests write their output to ansible.log in the working directory
+// until the returned function is called.
+func (t *roleTest) setup() (func(), error) {
+	release := func() {}
+
+	err := ensureDir(makePath(t.Dir, "callback_plugins"))
+
+	if err == nil {
+		err = removeFile(makePath(t.Dir, "ansible.log"))
+	}
+
+	if err == nil {
+		err = os.WriteFile(makePath(t.Dir, "callback_plugins", "k8s_dev.py"), []byte(resultsCallback), 0644)
+	}
+
+	if err == nil && len(t.Playbook) == 0 {
+		err = writeTestPlay(t.converge(), t.newPlay())
+	}
+
+	if err == nil && len(t.Playbook) > 0 {
+		err = writeVarsInventory(t.varsInventory(), t.newPlay().Vars)
+	}
+
+	// Nothing may be changed in the cluster in check mode.
+	if err == nil && len(t.Namespace) > 0 && !t.Options.Check {
+		err = ensureNamespace(t.Namespace, testNamespaceLabel+"="+t.Namespace)
+	}
+
+	if err == nil && t.Options.Quiet {
+		var log *os.File
+
+		log, err = os.OpenFile(makePath(t.Dir, "ansible.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+		if err == nil {
+			t.log = log
+
+			release = func() {
+				t.log = nil
+				log.Close()
+			}
+		}
+	}
+
+	return release, err
+}
+
+// output returns where the test prints its progress. Quiet tests write to
+// their log so tests running in parallel don't interleave their output.
+func (t *roleTest) output() io.Writer {
+	if t.log != nil {
+		return t.log
+	}
+
+	return os.Stdout
+}
+
+func (t *roleTest) message(msg string) {
+	fmt.Fprintln(t.output(), Yellow(msg))
+}
+
+func (t *roleTest) prepare() error {
+	t.message(fmt.Sprintf("Preparing '%s'...", t.Name()))
+
+	_, err := t.playbook(t.Scenario.Prepare)
+
+	return err
+}
+
+func (t *roleTest) runConverge() error {
+	t.message(fmt.Sprintf("Converging '%s'...", t.Name()))
+
+	_, err := t.playbook(t.converge())
+
+	return err
+}
+
+func (t *roleTest) idempotence() error {
+	t.message(fmt.Sprintf("Checking idempotence of '%s'...", t.Name()))
+
+	output, err := t.playbook(t.converge())
+	if err != nil {
+		return err
+	}
+
+	var changed []string
+
+	for _, task := range t.tasks {
+		if task.Changed && !slices.Contains(changed, task.Task) {
+			changed = append(changed, task.Task)
+		}
+	}
+
+	if len(changed) > 0 {
+		return fmt.Errorf("task(s) reported changed on the second run: %s", strings.Join(changed, ", "))
+	}
+
+	if changed := recapChanged(output); changed > 0 {
+		return fmt.Errorf("%d task(s) reported changed on the second run", changed)
+	}
+
+	return nil
+}
+
+func (t *roleTest) cleanup() error {
+	t.message(fmt.Sprintf("Cleaning up '%s'...", t.Name()))
+
+	_, err := t.playbook(t.Scenario.Cleanup)
+
+	return err
+}
+
+// converge returns the playbook that applies what is being tested.
+func (t *roleTest) converge() string {
+	if len(t.Playbook) > 0 {
+		return t.Playbook
+	}
+
+	return makePath(t.Dir, "play.yml")
+}
+
+func (t *roleTest) assert() error {
+	if len(t.Playbook) > 0 {
+		return skipPhase("not applicable to a playbook")
+	}
+
+	dir := t.Scenario.Asserts
+
+	if len(dir) == 0 {
+		if rd := roleDir(t.Role); len(rd) > 0 {
+			dir = makePath(rd, "asserts")
+		}
+	}
+
+	if len(dir) == 0 || !dirExists(dir) {
+		return skipPhase("no asserts directory found")
+	}
+
+	t.message(fmt.Sprintf("Asserting cluster state for '%s'...", t.Name()))
+
+	assertions, err := loadAssertions(dir, t.Options.AssertTimeout)
+	if err != nil {
+		return err
+	}
+
+	failures := 0
+
+	namespace := t.Namespace
+
+	if len(namespace) == 0 {
+		namespace = "default"
+	}
+
+	for _, r := range evaluateAssertions(assertions, namespace, t.output()) {
+		if !r.Passed {
+			failures++
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d assertion(s) failed", failures, len(assertions))
+	}
+
+	return nil
+}
+
+// verify runs the scenario's verify.yml or the role's tests/verify.yml. The
+// file is used as is when it is a playbook, otherwise it is treated as a list
+// of assertion tasks and wrapped in a generated play.
+func (t *roleTest) verify() error {
+	if len(t.Playbook) > 0 {
+		return skipPhase("not applicable to a playbook")
+	}
+
+	verify := t.Scenario.Verify
+
+	if len(verify) == 0 {
+		if rd := roleDir(t.Role); len(rd) > 0 && fileExists(makePath(rd, "tests", "verify.yml")) {
+			verify = makePath(rd, "tests", "verify.yml")
+		}
+	}
+
+	if len(verify) == 0 {
+		return skipPhase("no tests/verify.yml found")
+	}
+
+	content, err := os.ReadFile(verify)
+	if err != nil {
+		return err
+	}
+
+	var items []map[string]interface{}
+
+	if err := yaml.Unmarshal(content, &items); err != nil {
+		return fmt.Errorf("'%s' is not valid: %s", verify, err)
+	}
+
+	playbook := verify
+
+	if !isPlaybook(items) {
+		tasks, err := filepath.Abs(verify)
+		if err != nil {
+			return err
+		}
+
+		play := t.newPlay()
+		play.Name = fmt.Sprintf("Verify %s", t.Role)
+		play.Roles = nil
+		play.Tasks = []map[string]string{
+			{"ansible.builtin.include_tasks": tasks},
+		}
+
+		playbook = makePath(t.Dir, "verify.yml")
+
+		if err := writeTestPlay(playbook, play); err != nil {
+			return err
+		}
+	}
+
+	t.message(fmt.Sprintf("Verifying '%s'...", t.Name()))
+
+	_, err = t.playbook(playbook)
+
+	return err
+}
+
+func (t *roleTest) newPlay() testPlay {
+	// The play is written below .tmp so the kubectl configuration is passed as
+	// an absolute path rather than one relative to the play.
+	config, err := filepath.Abs(".kubectl.cfg")
+	if err != nil {
+		config = "../.kubectl.cfg"
+	}
+
+	play := testPlay{
+		Hosts:       "127.0.0.1",
+		GatherFacts: false,
+		Vars: map[string]interface{}{
+			"k8s_config": config,
+		},
+		Roles: []testPlayRole{
+			{Role: playRole(t.Role)},
+		},
+	}
+
+	if len(t.Scenario.Hosts) > 0 {
+		play.Hosts = t.Scenario.Hosts
+	}
+
+	if len(t.Options.Hosts) > 0 {
+		play.Hosts = t.Options.Hosts
+	}
+
+	// Roles applied to the nodes themselves usually depend on their facts.
+	if play.Hosts != "127.0.0.1" && play.Hosts != "localhost" {
+		play.GatherFacts = true
+	}
+
+	play.Become = t.Options.Become
+
+	if len(t.Namespace) > 0 {
+		play.Vars["test_namespace"] = t.Namespace
+	}
+
+	if len(t.Scenario.Vars) > 0 {
+		play.VarsFiles = append(play.VarsFiles, t.Scenario.Vars)
+	}
+
+	return play
+}
+
+// validate ensures the hosts requested on the command line exist in the
+// inventory used by the test.
+func (t *roleTest) validate() error {
+	if len(t.Options.Hosts) == 0 {
+		return nil
+	}
+
+	if len(t.Playbook) > 0 {
+		return fmt.Errorf("hosts can't be changed when testing the '%s' playbook", t.Playbook)
+	}
+
+	path := t.Scenario.Inventory
+
+	if len(path) == 0 {
+		path = "hosts.ini"
+	}
+
+	inv, err := readInventory(path)
+	if err != nil {
+		return err
+	}
+
+	return inv.validateTarget(t.Options.Hosts)
+}
+
+func (t *roleTest) playbook(playbook string) (string, error) {
+	var param []string
+
+	inventory := t.Scenario.Inventory
+
+	// A playbook under test doesn't have the generated play's variables, so
+	// they're provided by an additional inventory that keeps the precedence
+	// of the playbook's own variables.
+	if vars := t.varsInventory(); fileExists(vars) {
+		if len(inventory) == 0 {
+			inventory = "hosts.ini"
+		}
+
+		param = append(param, "--inventory", inventory)
+		inventory = vars
+	}
+
+	if len(inventory) > 0 {
+		param = append(param, "--inventory", inventory)
+	}
+
+	if t.Options.Verbose {
+		param = append(param, "-v")
+	}
+
+	if t.Options.Step {
+		param = append(param, "--step")
+	}
+
+	if t.Options.Check {
+		param = append(param, "--check")
+	}
+
+	if t.Options.Diff {
+		param = append(param, "--diff")
+	}
+
+	if len(t.Options.Tags) > 0 && playbook == t.converge() {
+		param = append(param, "--tags", t.Options.Tags)
+	}
+
+	param = append(param, playbook)
+
+	t.runs++
+
+	plugins, err := filepath.Abs(makePath(t.Dir, "callback_plugins"))
+	if err != nil {
+		return "", err
+	}
+
+	results, err := filepath.Abs(makePath(t.Dir, fmt.Sprintf("results-%d.json", t.runs)))
+	if err != nil {
+		return "", err
+	}
+
+	callbacks, err := ansibleCallbacks()
+	if err != nil {
+		return "", err
+	}
+
+	env := []string{
+		"ANSIBLE_FORCE_COLOR=true",
+		"ANSIBLE_CALLBACK_PLUGINS=" + strings.Join(append(slices.Clone(callbacks.Paths), plugins), string(os.PathListSeparator)),
+		"ANSIBLE_CALLBACKS_ENABLED=" + strings.Join(append(slices.Clone(callbacks.Enabled), "k8s_dev"), ","),
+		"K8S_DEV_RESULTS=" + results,
+	}
+
+	var output string
+
+	if t.log != nil {
+		output, err = executeExternalProgramLog("ansible-playbook", env, t.log, param...)
+	} else {
+		output, err = executeExternalProgramCapture("ansible-playbook", env, param...)
+	}
+
+	tasks, rerr := readTaskResults(results)
+	if rerr != nil {
+		fmt.Fprintln(t.output(), Teal(fmt.Sprintf("==> unable to read task results: %s", rerr)))
+	}
+
+	t.tasks = append(t.tasks, tasks...)
+
+	return output, err
+}
+
+func runPhase(name string, run func() error) phaseResult {
+	start := time.Now()
+	err := run()
+
+	phase := phaseResult{
+		Name:     name,
+		Status:   phasePassed,
+		Duration: time.Since(start),
+	}
+
+	var skip skipPhase
+
+	if errors.As(err, &skip) {
+		phase.Status = phaseSkipped
+		phase.Message = skip.Error()
+		phase.Duration = 0
+	} else if err != nil {
+		phase.Status = phaseFailed
+		phase.Message = err.Error()
+	}
+
+	return phase
+}
+
+func isPlaybook(items []map[string]interface{}) bool {
+	for _, item := range items {
+		if _, ok := item["hosts"]; ok {
+			return true
+		}
+
+		if _, ok := item["import_playbook"]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeTestPlay(path string, plays ...testPlay) error {
+	content, err := yaml.Marshal(plays)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append([]byte("---\n"), content...), 0644)
+}
+
+// varsInventory returns the inventory holding the variables of a tested
+// playbook.
+func (t *roleTest) varsInventory() string {
+	return makePath(t.Dir, "vars.yml")
+}
+
+// writeVarsInventory writes an inventory setting vars for all hosts, or
+// removes it when there are no variables.
+func writeVarsInventory(path string, vars map[string]interface{}) error {
+	if len(vars) == 0 {
+		return removeFile(path)
+	}
+
+	content, err := yaml.Marshal(map[string]interface{}{
+		"all": map[string]interface{}{
+			"vars": vars,
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append([]byte("---\n"), content...), 0644)
+}
+
+// runRoleTests runs tests, at most parallel at a time, returning the results
+// in the same order as tests.
+func runRoleTests(tests []*roleTest, parallel int) []testResult {
+	results := make([]testResult, len(tests))
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	slots := make(chan struct{}, parallel)
+
+	for i, t := range tests {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			slots <- struct{}{}
+			results[i] = t.Run()
+			<-slots
+
+			// The output of quiet tests is printed as a whole once each test
+			// finishes so it doesn't interleave with other tests.
+			if len(results[i].Log) > 0 {
+				mu.Lock()
+				printMessage(fmt.Sprintf("Output of '%s':", t.Name()))
+				fmt.Print(results[i].Log)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// resolveTestRoles expands the roles requested on the command line. Names
+// containing wildcards are matched against the directories in roles/ and all
+// selects every role in rolesDir, which may also be a collection.
+func resolveTestRoles(names []string, all bool, rolesDir string) ([]string, error) {
+	var roles []string
+
+	add := func(role string) {
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	if all {
+		prefix := ""
+
+		if filepath.Clean(rolesDir) != "roles" {
+			// Roles outside roles/ are referenced by their path.
+			prefix = filepath.Clean(rolesDir) + string(filepath.Separator)
+		}
+
+		if isCollection(rolesDir) {
+			info, err := readGalaxy(rolesDir)
+			if err != nil {
+				return roles, err
+			}
+
+			prefix = info.Namespace + "." + info.Name + "."
+			rolesDir = makePath(rolesDir, "roles")
+		}
+
+		entries, err := os.ReadDir(rolesDir)
+		if err != nil {
+			return roles, err
+		}
+
+		for _, e := range entries {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+				add(prefix + e.Name())
+			}
+		}
+
+		if len(roles) == 0 {
+			return roles, fmt.Errorf("'%s' does not contain any roles", rolesDir)
+		}
+	}
+
+	for _, name := range names {
+		if !strings.ContainsAny(name, "*?[") {
+			add(name)
+			continue
+		}
+
+		matches, err := filepath.Glob(makePath("roles", name))
+		if err != nil {
+			return roles, err
+		}
+
+		found := false
+
+		for _, m := range matches {
+			if dirExists(m) {
+				add(filepath.Base(m))
+				found = true
+			}
+		}
+
+		if !found {
+			return roles, fmt.Errorf("no roles match '%s'", name)
+		}
+	}
+
+	return roles, nil
+}
+
+// testNamespace returns a valid namespace name, unique to the test. A hash of
+// the name is added so names that sanitize or truncate to the same value get
+// distinct namespaces.
+func testNamespace(name string) string {
+	ns := "test-" + strings.Trim(regexp.MustCompile(`[^a-z0-9-]+`).ReplaceAllString(strings.ToLower(name), "-"), "-")
+
+	if len(ns) > 54 {
+		ns = strings.TrimRight(ns[:54], "-")
+	}
+
+	return ns + "-" + fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:8]
+}
+
+func testWorkDir(name string) string {
+	return makePath(".tmp", regexp.MustCompile(`[^A-Za-z0-9_.-]`).ReplaceAllString(name, "_"))
+}
+
+// playRole returns how role is referenced from a generated play. Plays are
+// written below .tmp, so role paths are made absolute.
+func playRole(role string) string {
+	if !isRolePath(role) {
+		return role
+	}
+
+	if abs, err := filepath.Abs(role); err == nil {
+		return abs
+	}
+
+	return role
+}
+
+// isRolePath reports whether role is the path of a role directory rather than
+// the name of a role in roles/ or a collection.
+func isRolePath(role string) bool {
+	return strings.ContainsRune(filepath.ToSlash(role), '/')
+}
+
+func roleDir(role string) string {
+	dir := makePath("roles", role)
+
+	switch {
+	case isRolePath(role):
+		dir = role
+	case isCollectionRole(role):
+		dir = collectionRoleDir(role)
+	}
+
+	if dirExists(dir) {
+		return dir
+	}
+
+	return ""
+}
+
+var (
+	ansiEscape    = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	recapCounters = regexp.MustCompile(`changed=(\d+)`)
+)
+
+// recapChanged totals the changed count of every host in the PLAY RECAP of
+// ansible-playbook output.
+func recapChanged(output string) int {
+	total := 0
+	recap := false
+
+	for _, line := range strings.Split(ansiEscape.ReplaceAllString(output, ""), "\n") {
+		if strings.HasPrefix(line, "PLAY RECAP") {
+			recap = true
+			continue
+		}
+
+		if recap {
+			if m := recapCounters.FindStringSubmatch(line); m != nil {
+				n, _ := strconv.Atoi(m[1])
+				total += n
+			}
+		}
+	}
+
+	return total
+}
+
+// printCheckSummary lists the tasks that would change when the role is
+// converged and the tasks that were skipped because their module doesn't
+// support check mode.
+func printCheckSummary(result testResult) {
+	var changed, unsupported []string
+
+	for _, p := range result.Phases {
+		if p.Name != "converge" {
+			continue
+		}
+
+		for _, task := range p.Tasks {
+			name := fmt.Sprintf("%s [%s]", task.Task, task.Host)
+
+			switch {
+			case task.Changed:
+				changed = append(changed, name)
+			case task.Status == "skipped" && strings.Contains(strings.ToLower(task.Message), "check mode"):
+				unsupported = append(unsupported, fmt.Sprintf("%s (%s)", name, task.Action))
+			}
+		}
+	}
+
+	printSubMessage(fmt.Sprintf("%d task(s) of '%s' would change", len(changed), result.Name()))
+
+	for _, c := range changed {
+		fmt.Println(Yellow("  ~ " + c))
+	}
+
+	if len(unsupported) > 0 {
+		printSubMessage(fmt.Sprintf("%d task(s) of '%s' don't support check mode", len(unsupported), result.Name()))
+
+		for _, u := range unsupported {
+			fmt.Println(Magenta("  ? " + u))
+		}
+	}
+}
+
+func printTestResult(result testResult) {
+	for _, p := range result.Phases {
+		status := Green(strings.ToUpper(p.Status))
+
+		switch p.Status {
+		case phaseFailed:
+			status = Red(strings.ToUpper(p.Status))
+		case phaseSkipped:
+			status = Yellow(strings.ToUpper(p.Status))
+		}
+
+		line := fmt.Sprintf("  %-12s %s", p.Name, status)
+
+		if p.Duration > 0 {
+			line = fmt.Sprintf("%s (%s)", line, p.Duration.Round(time.Millisecond))
+		}
+
+		if len(p.Message) > 0 {
+			line = fmt.Sprintf("%s: %s", line, p.Message)
+		}
+
+		fmt.Println(line)
+	}
+
+	if result.Passed() {
+		fmt.Println(Green(fmt.Sprintf("'%s' passed in %s", result.Name(), result.Duration.Round(time.Millisecond))))
+	} else {
+		fmt.Println(Red(fmt.Sprintf("'%s' failed in %s", result.Name(), result.Duration.Round(time.Millisecond))))
+	}
+}
+
+func printTestSummary(results []testResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ROLE\tSCENARIO\tRESULT\tDURATION")
+
+	for _, r := range results {
+		scenario := r.Scenario
+
+		if len(scenario) == 0 {
+			scenario = "-"
+		}
+
+		status := "PASSED"
+
+		if !r.Passed() {
+			status = fmt.Sprintf("FAILED (%s)", r.failed())
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Role, scenario, status, r.Duration.Round(time.Millisecond))
+	}
+
+	w.Flush()
+}
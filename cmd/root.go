@@ -65,6 +65,22 @@ func ensureKubectlfile() error {
 	return nil
 }
 
+// ensureEnvironment ensures a deployed Vagrant or Minikube environment can be
+// reached with the project's kubectl configuration.
+func ensureEnvironment() error {
+	if !isVagrantEnv() && !isMinikubeEnv() {
+		return fmt.Errorf("a Kubernetes development environment not found")
+	}
+
+	if isVagrantEnv() {
+		if err := ensureVagrantfile(); err != nil {
+			return err
+		}
+	}
+
+	return ensureKubectlfile()
+}
+
 func ensureRootDirectory() {
 	if workingDirectory != folderPath {
 		err := os.Chdir(folderPath)
@@ -0,0 +1,47 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var runCmd = &cobra.Command{
+	Use:               "run <playbook>",
+	Args:              cobra.ExactArgs(1),
+	Short:             "Run a project playbook against the Kubernetes development environment",
+	Long:              "Run a project playbook against the Kubernetes development environment",
+	ValidArgsFunction: completePlaybooks,
+	Run: func(cmd *cobra.Command, args []string) {
+		playbook, err := resolvePlaybook(args[0])
+		cobra.CheckErr(err)
+
+		env := []string{
+			"K8S_AUTH_VERIFY_SSL=false",
+		}
+
+		cobra.CheckErr(runPlaybook(cmd, env, playbook))
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		ensureRootDirectory()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	addAnsibleFlags(runCmd)
+}
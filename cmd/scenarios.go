@@ -0,0 +1,179 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A testScenario is a directory below a role's tests/scenarios folder. Every
+// file in it is optional:
+//
+//	scenario.yml  the hosts the role is applied to ("hosts: <pattern>")
+//	vars.yml      variables added to the generated play
+//	inventory     inventory used instead of the project's hosts.ini
+//	prepare.yml   playbook run before the role is converged
+//	cleanup.yml   playbook run after all other phases, even on failure
+//	verify.yml    used instead of the role's tests/verify.yml
+//	asserts/      used instead of the role's asserts directory
+type testScenario struct {
+	Name      string `yaml:"-"`
+	Dir       string `yaml:"-"`
+	Hosts     string `yaml:"hosts"`
+	Inventory string `yaml:"-"`
+	Vars      string `yaml:"-"`
+	Prepare   string `yaml:"-"`
+	Cleanup   string `yaml:"-"`
+	Verify    string `yaml:"-"`
+	Asserts   string `yaml:"-"`
+}
+
+func scenariosDir(role string) string {
+	rd := roleDir(role)
+
+	if len(rd) == 0 {
+		return ""
+	}
+
+	return makePath(rd, "tests", "scenarios")
+}
+
+func roleScenarios(role string) ([]string, error) {
+	var names []string
+
+	dir := scenariosDir(role)
+
+	if len(dir) == 0 || !dirExists(dir) {
+		return names, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return names, err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func loadScenario(role, name string) (testScenario, error) {
+	scenario := testScenario{
+		Name: name,
+		Dir:  makePath(scenariosDir(role), name),
+	}
+
+	if !dirExists(scenario.Dir) {
+		return scenario, fmt.Errorf("'%s' does not have a '%s' scenario", role, name)
+	}
+
+	if file := makePath(scenario.Dir, "scenario.yml"); fileExists(file) {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return scenario, err
+		}
+
+		if err := yaml.Unmarshal(content, &scenario); err != nil {
+			return scenario, fmt.Errorf("'%s' is not valid: %s", file, err)
+		}
+	}
+
+	if file := makePath(scenario.Dir, "vars.yml"); fileExists(file) {
+		// The generated play lives below .tmp, so vars_files needs an
+		// absolute path.
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return scenario, err
+		}
+
+		scenario.Vars = abs
+	}
+
+	for _, inventory := range []string{"inventory", "hosts.ini", "inventory.yml"} {
+		if file := makePath(scenario.Dir, inventory); fileExists(file) {
+			scenario.Inventory = file
+			break
+		}
+	}
+
+	if file := makePath(scenario.Dir, "prepare.yml"); fileExists(file) {
+		scenario.Prepare = file
+	}
+
+	if file := makePath(scenario.Dir, "cleanup.yml"); fileExists(file) {
+		scenario.Cleanup = file
+	}
+
+	if file := makePath(scenario.Dir, "verify.yml"); fileExists(file) {
+		scenario.Verify = file
+	}
+
+	if dir := makePath(scenario.Dir, "asserts"); dirExists(dir) {
+		scenario.Asserts = dir
+	}
+
+	return scenario, nil
+}
+
+// testScenarios returns the scenarios of role selected by names or all. With
+// neither, the "default" scenario is used when the role has one; otherwise
+// the role is tested without a scenario.
+func testScenarios(role string, names []string, all bool) ([]testScenario, error) {
+	var scenarios []testScenario
+
+	if all {
+		available, err := roleScenarios(role)
+		if err != nil {
+			return scenarios, err
+		}
+
+		if len(available) == 0 {
+			return scenarios, fmt.Errorf("'%s' does not have any scenarios", role)
+		}
+
+		names = available
+	}
+
+	if len(names) == 0 {
+		if dir := scenariosDir(role); len(dir) > 0 && dirExists(makePath(dir, "default")) {
+			names = []string{"default"}
+		} else {
+			return append(scenarios, testScenario{}), nil
+		}
+	}
+
+	for _, name := range names {
+		scenario, err := loadScenario(role, name)
+		if err != nil {
+			return scenarios, err
+		}
+
+		scenarios = append(scenarios, scenario)
+	}
+
+	return scenarios, nil
+}
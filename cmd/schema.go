@@ -0,0 +1,327 @@
+/*
+Copyright © 2024 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const schemaURL = "https://raw.githubusercontent.com/kubernetes/kubernetes/%s/api/openapi-spec/swagger.json"
+
+type schema = map[string]interface{}
+
+// schemaValidator validates objects against the OpenAPI definitions of a
+// Kubernetes version and the schemas of custom resource definitions.
+type schemaValidator struct {
+	definitions map[string]schema
+	kinds       map[string]schema
+}
+
+type schemaViolation struct {
+	Field   string
+	Message string
+}
+
+// kubernetesVersion converts a k3s version such as v1.30.2+k3s2 into the
+// Kubernetes version it is built from.
+func kubernetesVersion(version string) string {
+	version, _, _ = strings.Cut(strings.TrimSpace(version), "+")
+
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	return version
+}
+
+func schemaCacheFile(version string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return makePath(dir, "k8s-dev", "schemas", version+".json"), nil
+}
+
+// loadSchemas returns a validator for the Kubernetes version, downloading the
+// OpenAPI definitions into the cache the first time unless offline.
+func loadSchemas(version, url string, offline bool) (*schemaValidator, error) {
+	path, err := schemaCacheFile(version)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fileExists(path) {
+		if offline {
+			return nil, fmt.Errorf("the schemas for Kubernetes %s are not cached, run 'k8s-dev validate' once while online", version)
+		}
+
+		if err := downloadSchemas(fmt.Sprintf(url, version), path); err != nil {
+			return nil, err
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec struct {
+		Definitions map[string]schema `json:"definitions"`
+	}
+
+	if err := json.Unmarshal(content, &spec); err != nil {
+		return nil, fmt.Errorf("the cached schemas in '%s' are invalid: %w", path, err)
+	}
+
+	v := &schemaValidator{
+		definitions: spec.Definitions,
+		kinds:       map[string]schema{},
+	}
+
+	for _, definition := range spec.Definitions {
+		kinds, _ := definition["x-kubernetes-group-version-kind"].([]interface{})
+
+		for _, k := range kinds {
+			gvk, _ := k.(map[string]interface{})
+			group, _ := gvk["group"].(string)
+			version, _ := gvk["version"].(string)
+			kind, _ := gvk["kind"].(string)
+
+			v.kinds[schemaKey(group, version, kind)] = definition
+		}
+	}
+
+	return v, nil
+}
+
+func downloadSchemas(url, path string) error {
+	printSubMessage(fmt.Sprintf("downloading schemas from '%s'", url))
+
+	client := http.Client{Timeout: 2 * time.Minute}
+
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to download the schemas: %s", response.Status)
+	}
+
+	if err := ensureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0644)
+}
+
+func schemaKey(group, version, kind string) string {
+	if len(group) == 0 {
+		return version + "/" + kind
+	}
+
+	return group + "/" + version + "/" + kind
+}
+
+// addCRD registers the schema of every served version of a custom resource
+// definition.
+func (v *schemaValidator) addCRD(crd map[string]interface{}) {
+	spec, _ := crd["spec"].(map[string]interface{})
+	group, _ := spec["group"].(string)
+	names, _ := spec["names"].(map[string]interface{})
+	kind, _ := names["kind"].(string)
+	versions, _ := spec["versions"].([]interface{})
+
+	for _, item := range versions {
+		version, _ := item.(map[string]interface{})
+		name, _ := version["name"].(string)
+		validation, _ := version["schema"].(map[string]interface{})
+
+		if s, ok := validation["openAPIV3Schema"].(map[string]interface{}); ok {
+			v.kinds[schemaKey(group, name, kind)] = s
+		}
+	}
+}
+
+// Validate returns the violations of object, or an error when there is no
+// schema for its kind.
+func (v *schemaValidator) Validate(object map[string]interface{}) ([]schemaViolation, error) {
+	apiVersion, _ := object["apiVersion"].(string)
+	kind, _ := object["kind"].(string)
+
+	s, ok := v.kinds[apiVersion+"/"+kind]
+
+	if !ok {
+		return nil, fmt.Errorf("no schema for %s %s", apiVersion, kind)
+	}
+
+	return v.validate(s, object, ""), nil
+}
+
+func (v *schemaValidator) validate(s schema, value interface{}, path string) []schemaViolation {
+	if ref, ok := s["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/definitions/")
+
+		// Quantities are commonly written as numbers.
+		if strings.HasSuffix(name, ".Quantity") {
+			return nil
+		}
+
+		definition, ok := v.definitions[name]
+		if !ok {
+			return nil
+		}
+
+		return v.validate(definition, value, path)
+	}
+
+	if value == nil {
+		return nil
+	}
+
+	if s["x-kubernetes-int-or-string"] == true || s["format"] == "int-or-string" {
+		switch value.(type) {
+		case int, int64, uint64, string:
+			return nil
+		}
+
+		return []schemaViolation{{path, "must be an integer or a string"}}
+	}
+
+	var violations []schemaViolation
+
+	if t, ok := s["type"].(string); ok && !schemaType(t, value) {
+		return []schemaViolation{{path, fmt.Sprintf("must be of type %s, not %s", t, valueType(value))}}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok && !slices.ContainsFunc(enum, func(e interface{}) bool {
+		return fmt.Sprint(e) == fmt.Sprint(value)
+	}) {
+		violations = append(violations, schemaViolation{path, fmt.Sprintf("must be one of %v", enum)})
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		properties, _ := s["properties"].(map[string]interface{})
+		additional, hasAdditional := s["additionalProperties"].(map[string]interface{})
+		preserve := s["x-kubernetes-preserve-unknown-fields"] == true
+
+		required, _ := s["required"].([]interface{})
+
+		for _, r := range required {
+			if _, ok := value[fmt.Sprint(r)]; !ok {
+				violations = append(violations, schemaViolation{schemaField(path, fmt.Sprint(r)), "is required"})
+			}
+		}
+
+		keys := make([]string, 0, len(value))
+
+		for k := range value {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			field := schemaField(path, k)
+
+			if p, ok := properties[k].(map[string]interface{}); ok {
+				violations = append(violations, v.validate(p, value[k], field)...)
+
+				continue
+			}
+
+			switch {
+			case hasAdditional:
+				violations = append(violations, v.validate(additional, value[k], field)...)
+			case preserve || s["additionalProperties"] == true:
+			case len(properties) > 0:
+				violations = append(violations, schemaViolation{field, "is not a known field"})
+			}
+		}
+	case []interface{}:
+		if items, ok := s["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				violations = append(violations, v.validate(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+
+	return violations
+}
+
+func schemaType(t string, value interface{}) bool {
+	switch value.(type) {
+	case map[string]interface{}:
+		return t == "object"
+	case []interface{}:
+		return t == "array"
+	case string, time.Time:
+		return t == "string"
+	case bool:
+		return t == "boolean"
+	case int, int64, uint64:
+		return t == "integer" || t == "number"
+	case float64:
+		return t == "number"
+	}
+
+	return true
+}
+
+func valueType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string, time.Time:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64, uint64:
+		return "integer"
+	case float64:
+		return "number"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaField(path, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+
+	return path + "." + name
+}
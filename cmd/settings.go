@@ -0,0 +1,106 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+const settingsFile = "k8s-dev.yml"
+
+type projectSettings struct {
+	Box        string            `yaml:"box,omitempty"`
+	BoxVersion string            `yaml:"box_version,omitempty"`
+	Configure  configureSettings `yaml:"configure,omitempty"`
+	Matrix     matrixSettings    `yaml:"matrix,omitempty"`
+	Registry   registrySettings  `yaml:"registry,omitempty"`
+
+	Repositories []helmRepository `yaml:"repositories,omitempty"`
+	Releases     []helmRelease    `yaml:"releases,omitempty"`
+}
+
+type helmRepository struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+// helmRelease declares a release installed by 'releases sync'. Chart is either
+// a path to a local chart or a repository reference such as repo/chart.
+type helmRelease struct {
+	Name      string   `yaml:"name"`
+	Chart     string   `yaml:"chart"`
+	Version   string   `yaml:"version,omitempty"`
+	Namespace string   `yaml:"namespace,omitempty"`
+	Values    []string `yaml:"values,omitempty"`
+	Set       []string `yaml:"set,omitempty"`
+	Needs     []string `yaml:"needs,omitempty"`
+	Installed *bool    `yaml:"installed,omitempty"`
+}
+
+type configureSettings struct {
+	Pre  []string `yaml:"pre,omitempty"`
+	Post []string `yaml:"post,omitempty"`
+}
+
+// matrixSettings declares the environments the matrix command tests against.
+type matrixSettings struct {
+	Boxes       []string `yaml:"boxes,omitempty"`
+	K3sVersions []string `yaml:"k3s_versions,omitempty"`
+	CNI         []string `yaml:"cni,omitempty"`
+	Tests       []string `yaml:"tests,omitempty"`
+}
+
+// registrySettings records the local registry set up by 'registry enable' so
+// 'registry push' knows where to push images.
+type registrySettings struct {
+	Mode     string `yaml:"mode,omitempty"`
+	Port     int    `yaml:"port,omitempty"`
+	Endpoint string `yaml:"endpoint,omitempty"`
+}
+
+func readSettings(dir string) (projectSettings, error) {
+	var settings projectSettings
+
+	path := makePath(dir, settingsFile)
+
+	if !fileExists(path) {
+		return settings, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return settings, err
+	}
+
+	err = yaml.Unmarshal(content, &settings)
+
+	return settings, err
+}
+
+func loadSettings() (projectSettings, error) {
+	return readSettings(".")
+}
+
+func saveSettings(settings projectSettings) error {
+	content, err := yaml.Marshal(&settings)
+	if err != nil {
+		return err
+	}
+
+	return createFile(settingsFile, append([]byte("---\n"), content...))
+}
@@ -0,0 +1,345 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Resources that change on their own and would only hide what a test did.
+var noisyResources = []string{
+	"componentstatuses",
+	"controllerrevisions.apps",
+	"endpoints",
+	"endpointslices.discovery.k8s.io",
+	"events",
+	"events.events.k8s.io",
+	"leases.coordination.k8s.io",
+	"nodes.metrics.k8s.io",
+	"pods.metrics.k8s.io",
+}
+
+// Metadata fields maintained by the API server rather than by a test.
+var noisyMetadata = []string{
+	"creationTimestamp",
+	"generation",
+	"managedFields",
+	"resourceVersion",
+	"selfLink",
+	"uid",
+}
+
+var noisyAnnotations = []string{
+	"deployment.kubernetes.io/revision",
+	"kubectl.kubernetes.io/last-applied-configuration",
+}
+
+// clusterSnapshot holds every object in the cluster, keyed by its group, kind,
+// namespace and name.
+type clusterSnapshot map[string]map[string]interface{}
+
+type clusterDiff struct {
+	Added   []string        `json:"added"`
+	Removed []string        `json:"removed"`
+	Changed []changedObject `json:"changed"`
+}
+
+type changedObject struct {
+	Object string        `json:"object"`
+	Fields []fieldChange `json:"fields"`
+}
+
+type fieldChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// takeClusterSnapshot lists the objects of every resource returned by
+// 'kubectl api-resources' with params, such as --namespaced=false.
+func takeClusterSnapshot(params ...string) (clusterSnapshot, error) {
+	snapshot := clusterSnapshot{}
+
+	resources, err := listableResources(params...)
+	if err != nil {
+		return snapshot, err
+	}
+
+	objects, err := listObjects(resources)
+	if err != nil {
+		return snapshot, err
+	}
+
+	for _, object := range objects {
+		normalizeObject(object)
+
+		snapshot[snapshotKey(object)] = object
+	}
+
+	return snapshot, nil
+}
+
+func listableResources(params ...string) ([]string, error) {
+	var resources []string
+
+	output, err := kubectl(append([]string{"api-resources", "--verbs=list", "--output=name"}, params...)...)
+	if err != nil {
+		return resources, err
+	}
+
+	for _, r := range strings.Fields(output) {
+		if !slices.Contains(noisyResources, r) {
+			resources = append(resources, r)
+		}
+	}
+
+	return resources, nil
+}
+
+// listObjects returns the objects of resources in every namespace, limited by
+// params such as a label selector.
+func listObjects(resources []string, params ...string) ([]map[string]interface{}, error) {
+	return getObjects(resources, append([]string{"--all-namespaces"}, params...)...)
+}
+
+// getObjects returns the objects of resources limited by params, such as a
+// namespace or a label selector.
+func getObjects(resources []string, params ...string) ([]map[string]interface{}, error) {
+	var list struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+
+	err := kubectlJSON(&list, append([]string{"get", strings.Join(resources, ",")}, params...)...)
+
+	if err == nil || len(resources) < 2 {
+		return list.Items, err
+	}
+
+	// An unavailable aggregated API fails the whole list, so list the
+	// resources one at a time and skip the ones that can't be listed.
+	var objects []map[string]interface{}
+
+	for _, r := range resources {
+		list.Items = nil
+
+		if err := kubectlJSON(&list, append([]string{"get", r}, params...)...); err != nil {
+			printSubMessage(fmt.Sprintf("skipping '%s': %s", r, err))
+
+			continue
+		}
+
+		objects = append(objects, list.Items...)
+	}
+
+	return objects, nil
+}
+
+func snapshotKey(object map[string]interface{}) string {
+	apiVersion, _ := object["apiVersion"].(string)
+	kind, _ := object["kind"].(string)
+	namespace := objectField(object, "metadata", "namespace")
+	name := objectField(object, "metadata", "name")
+
+	group := ""
+
+	if g, _, found := strings.Cut(apiVersion, "/"); found {
+		group = "." + g
+	}
+
+	if len(namespace) > 0 {
+		return fmt.Sprintf("%s%s %s/%s", kind, group, namespace, name)
+	}
+
+	return fmt.Sprintf("%s%s %s", kind, group, name)
+}
+
+func normalizeObject(object map[string]interface{}) {
+	delete(object, "status")
+
+	if object["kind"] == "Secret" {
+		// The values of secrets are replaced by a hash so a diff shows which
+		// keys changed without printing or saving their contents.
+		for _, field := range []string{"data", "stringData"} {
+			if data, ok := object[field].(map[string]interface{}); ok {
+				for k, v := range data {
+					data[k] = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(fmt.Sprint(v))))
+				}
+			}
+		}
+	}
+
+	metadata, ok := object["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for _, field := range noisyMetadata {
+		delete(metadata, field)
+	}
+
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+		for _, a := range noisyAnnotations {
+			delete(annotations, a)
+		}
+
+		if len(annotations) == 0 {
+			delete(metadata, "annotations")
+		}
+	}
+}
+
+func diffSnapshots(before, after clusterSnapshot) clusterDiff {
+	diff := clusterDiff{}
+
+	for key, object := range after {
+		previous, found := before[key]
+
+		if !found {
+			diff.Added = append(diff.Added, key)
+			continue
+		}
+
+		if changes := objectChanges(previous, object, ""); len(changes) > 0 {
+			diff.Changed = append(diff.Changed, changedObject{
+				Object: key,
+				Fields: changes,
+			})
+		}
+	}
+
+	for key := range before {
+		if _, found := after[key]; !found {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].Object < diff.Changed[j].Object
+	})
+
+	return diff
+}
+
+func objectChanges(before, after interface{}, path string) []fieldChange {
+	var changes []fieldChange
+
+	b, bok := before.(map[string]interface{})
+	a, aok := after.(map[string]interface{})
+
+	if !bok || !aok {
+		if !reflect.DeepEqual(before, after) {
+			changes = append(changes, fieldChange{
+				Path:   path,
+				Before: before,
+				After:  after,
+			})
+		}
+
+		return changes
+	}
+
+	var keys []string
+
+	for k := range b {
+		keys = append(keys, k)
+	}
+
+	for k := range a {
+		if _, found := b[k]; !found {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		field := k
+
+		if len(path) > 0 {
+			field = path + "." + k
+		}
+
+		changes = append(changes, objectChanges(b[k], a[k], field)...)
+	}
+
+	return changes
+}
+
+func (d clusterDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d clusterDiff) Print() {
+	if d.Empty() {
+		printSubMessage("the cluster was not changed")
+
+		return
+	}
+
+	printSubMessage(fmt.Sprintf("cluster changes: %d added, %d changed, %d removed",
+		len(d.Added), len(d.Changed), len(d.Removed)))
+
+	for _, key := range d.Added {
+		fmt.Println(Green("  + " + key))
+	}
+
+	for _, c := range d.Changed {
+		fmt.Println(Yellow("  ~ " + c.Object))
+
+		for _, f := range c.Fields {
+			fmt.Println(fieldDiffLine(f))
+		}
+	}
+
+	for _, key := range d.Removed {
+		fmt.Println(Red("  - " + key))
+	}
+}
+
+func fieldDiffLine(f fieldChange) string {
+	value := func(v interface{}) string {
+		if v == nil {
+			return "<none>"
+		}
+
+		content, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+
+		return string(content)
+	}
+
+	return fmt.Sprintf("      %s: %s -> %s", f.Path, value(f.Before), value(f.After))
+}
+
+func (d clusterDiff) Save(path string) error {
+	content, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0644)
+}
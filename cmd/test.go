@@ -19,62 +19,249 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var testCmd = &cobra.Command{
-	Use:   "test <role>",
-	Args:  cobra.ExactArgs(1),
-	Short: "Test a role against Kubernetes development environment",
-	Long:  "Test a role against Kubernetes development environment",
+	Use:   "test [role|namespace.collection.role|playbook...]",
+	Short: "Test roles against Kubernetes development environment",
+	Long: `Test a role against Kubernetes development environment. The role is converged, the objects
+declared in the role's asserts directory are checked against the cluster, the role is converged a second
+time to ensure no task reports changed, and then verified with the tests/verify.yml playbook or assertion
+tasks found in the role. Role names may contain wildcards matched against the roles directory. Fully
+qualified collection roles and playbooks can be tested too, and collections under development can be
+installed into the project's collections path before testing.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		name := args[0]
+		converge, _ := cmd.Flags().GetBool("converge")
+		assert, _ := cmd.Flags().GetBool("assert")
+		timeout, _ := cmd.Flags().GetDuration("assert-timeout")
+		idempotence, _ := cmd.Flags().GetBool("idempotence")
+		verify, _ := cmd.Flags().GetBool("verify")
 		step, _ := cmd.Flags().GetBool("step")
 		verbose, _ := cmd.Flags().GetBool("verbose")
+		hosts, _ := cmd.Flags().GetString("hosts")
+		become, _ := cmd.Flags().GetBool("become")
+		check, _ := cmd.Flags().GetBool("check")
+		diff, _ := cmd.Flags().GetBool("diff")
 
-		if fileExists(".tmp/play.yml") {
-			cobra.CheckErr(os.Remove(".tmp/play.yml"))
+		opts := testOptions{
+			Converge:      converge,
+			Assert:        assert,
+			AssertTimeout: timeout,
+			Idempotence:   idempotence,
+			Verify:        verify,
+			Hosts:         hosts,
+			Become:        become,
+			Check:         check,
+			Diff:          diff,
+			Step:          step,
+			Verbose:       verbose,
 		}
 
-		if !dirExists(".tmp") {
-			cobra.CheckErr(os.Mkdir(".tmp", 0755))
+		if check {
+			// Nothing is applied in check mode, so there is nothing to assert,
+			// repeat or verify.
+			opts.Assert = false
+			opts.Idempotence = false
+			opts.Verify = false
 		}
 
-		file, err := os.Create(".tmp/play.yml")
+		all, _ := cmd.Flags().GetBool("all")
+		rolesDir, _ := cmd.Flags().GetString("roles-dir")
+		parallel, _ := cmd.Flags().GetInt("parallel")
 
-		if err != nil {
+		opts.Isolate, _ = cmd.Flags().GetBool("isolate")
+		opts.Quiet = parallel > 1
+
+		cleanup, _ := cmd.Flags().GetBool("cleanup")
+
+		if opts.Quiet || cleanup {
+			// Concurrent tests would otherwise share the default namespace,
+			// and cleanup deletes the namespace of each test.
+			opts.Isolate = true
+		}
+
+		collections, _ := cmd.Flags().GetStringArray("collection")
+
+		if all && isCollection(rolesDir) && !slices.Contains(collections, rolesDir) {
+			collections = append(collections, rolesDir)
+		}
+
+		for _, c := range collections {
+			cobra.CheckErr(installCollection(c))
+		}
+
+		roles, err := resolveTestRoles(args, all, rolesDir)
+		cobra.CheckErr(err)
+
+		names, _ := cmd.Flags().GetStringArray("scenario")
+		allScenarios, _ := cmd.Flags().GetBool("all-scenarios")
+
+		var tests []*roleTest
+
+		for _, role := range roles {
+			if isPlaybookFile(role) {
+				tests = append(tests, newPlaybookTest(role, opts))
+				continue
+			}
+
+			scenarios, err := testScenarios(role, names, allScenarios)
 			cobra.CheckErr(err)
+
+			for _, scenario := range scenarios {
+				t := newRoleTest(role, scenario, opts)
+
+				cobra.CheckErr(t.validate())
+
+				tests = append(tests, t)
+			}
 		}
 
-		defer file.Close()
+		if watch, _ := cmd.Flags().GetBool("watch"); watch {
+			if len(tests) != 1 || len(tests[0].Playbook) > 0 {
+				cobra.CheckErr(errors.New("watch requires exactly one role and scenario"))
+			}
+
+			tags, _ := cmd.Flags().GetBool("watch-tags")
 
-		content := "---\n- hosts: 127.0.0.1\n  gather_facts: false\n\n  vars:\n    k8s_config: ../.kubectl.cfg\n\n  roles:\n"
-		content = fmt.Sprintf("%s%s", content, fmt.Sprintf("    - role: %s\n", name))
+			// Assertions only run on every change when asked for explicitly.
+			tests[0].Options.Assert = tests[0].Options.Assert && cmd.Flags().Changed("assert")
+			tests[0].Options.Idempotence = false
+			tests[0].Options.Verify = false
 
-		if _, err = file.WriteString(content); err != nil {
+			err := watchRoleTest(tests[0], tags)
+
+			cobra.CheckErr(os.RemoveAll(".tmp"))
 			cobra.CheckErr(err)
+
+			return
 		}
 
-		var param []string
+		diffFile, _ := cmd.Flags().GetString("cluster-diff-file")
+		clusterDiff, _ := cmd.Flags().GetBool("cluster-diff")
+		clusterDiff = clusterDiff || len(diffFile) > 0
 
-		if verbose {
-			param = append(param, "-v")
+		var before clusterSnapshot
+
+		if clusterDiff {
+			printSubMessage("taking a snapshot of the cluster before testing")
+
+			before, err = takeClusterSnapshot()
+			cobra.CheckErr(err)
 		}
 
-		if step {
-			param = append(param, "--step")
+		var cleanupBefore clusterSnapshot
+
+		if cleanup {
+			cleanupBefore, err = takeClusterSnapshot("--namespaced=false")
+			cobra.CheckErr(err)
 		}
 
-		param = append(param, ".tmp/play.yml")
+		results := runRoleTests(tests, parallel)
+
+		var after clusterSnapshot
 
-		cobra.CheckErr(executeExternalProgram("ansible-playbook", param...))
+		if clusterDiff {
+			// The snapshot is taken before cleanup so the diff shows what the
+			// test run changed.
+			after, err = takeClusterSnapshot()
+			cobra.CheckErr(err)
+		}
 
 		cobra.CheckErr(os.RemoveAll(".tmp"))
+
+		if cleanup {
+			keep, _ := cmd.Flags().GetBool("keep-on-failure")
+
+			cobra.CheckErr(cleanupTestRun(tests, results, cleanupBefore, keep))
+		}
+
+		if clusterDiff {
+			diff := diffSnapshots(before, after)
+			diff.Print()
+
+			if len(diffFile) > 0 {
+				cobra.CheckErr(diff.Save(diffFile))
+			}
+		}
+
+		if dir, _ := cmd.Flags().GetString("report-dir"); len(dir) > 0 {
+			cobra.CheckErr(writeTestReports(dir, results))
+		}
+
+		failed := 0
+
+		for _, result := range results {
+			printTestResult(result)
+
+			if check {
+				printCheckSummary(result)
+			}
+
+			if !result.Passed() {
+				failed++
+			}
+		}
+
+		if len(results) > 1 {
+			fmt.Println()
+			printTestSummary(results)
+		}
+
+		if withCoverage, _ := cmd.Flags().GetBool("coverage"); withCoverage {
+			format, _ := cmd.Flags().GetString("coverage-format")
+
+			var coverage []roleCoverage
+
+			for _, t := range tests {
+				if len(t.Playbook) > 0 || slices.ContainsFunc(coverage, func(c roleCoverage) bool { return c.Role == t.Role }) {
+					continue
+				}
+
+				c, err := newRoleCoverage(t.Role, results)
+				cobra.CheckErr(err)
+
+				coverage = append(coverage, c)
+			}
+
+			fmt.Println()
+			printCoverage(coverage)
+
+			if format != "text" {
+				path, _ := cmd.Flags().GetString("coverage-file")
+
+				if len(path) == 0 {
+					path = "coverage." + format
+
+					if dir, _ := cmd.Flags().GetString("report-dir"); len(dir) > 0 {
+						path = makePath(dir, path)
+					}
+				}
+
+				cobra.CheckErr(writeCoverage(path, format, coverage))
+			}
+		}
+
+		if failed > 0 {
+			cobra.CheckErr(fmt.Errorf("%d of %d test run(s) failed", failed, len(results)))
+		}
 	},
 	PreRun: func(cmd *cobra.Command, args []string) {
 		ensureRootDirectory()
 
+		if format, _ := cmd.Flags().GetString("coverage-format"); !slices.Contains([]string{"text", "json", "html"}, format) {
+			cobra.CheckErr(fmt.Errorf("'%s' is invalid for coverage-format. Valid options: text, json, html", format))
+		}
+
+		all, _ := cmd.Flags().GetBool("all")
+
+		if len(args) == 0 && !all {
+			cobra.CheckErr(errors.New("at least one role or --all is required"))
+		}
+
 		if !isVagrantEnv() && !isMinikubeEnv() {
 			cobra.CheckErr(errors.New("a Kubernetes development environment not found"))
 		}
@@ -92,4 +279,30 @@ func init() {
 
 	testCmd.Flags().BoolP("verbose", "v", false, "tell Ansible to print more debug messages")
 	testCmd.Flags().Bool("step", false, "one-step-at-a-time: confirm each task before running")
+	testCmd.Flags().Bool("all", false, "test every role in the roles directory")
+	testCmd.Flags().String("roles-dir", "roles", "roles directory, or collection, used by --all")
+	testCmd.Flags().StringArray("collection", []string{}, "install the collection at this path into ./collections before testing (can be repeated)")
+	testCmd.Flags().IntP("parallel", "j", 1, "number of tests to run at the same time")
+	testCmd.Flags().Bool("isolate", false, "run each test in its own namespace, passed to the role as 'test_namespace'")
+	testCmd.Flags().StringArray("scenario", []string{}, "run the named scenario from the role's tests/scenarios directory (can be repeated)")
+	testCmd.Flags().Bool("all-scenarios", false, "run every scenario from the role's tests/scenarios directory")
+	testCmd.Flags().Bool("check", false, "converge the role in check mode and summarise the tasks that would change")
+	testCmd.Flags().Bool("diff", false, "show the differences in files and templates being changed")
+	testCmd.Flags().String("hosts", "", "apply the role to a node or the master, node or k3s_cluster group instead of localhost")
+	testCmd.Flags().Bool("become", false, "run the role with privilege escalation")
+	testCmd.Flags().BoolP("watch", "w", false, "converge the role again every time its files change")
+	testCmd.Flags().Bool("watch-tags", false, "when only task files changed, run the tasks tagged with the changed file names")
+	testCmd.Flags().Bool("cleanup", false, "run each test in its own namespace and delete it, and any cluster-scoped objects created, afterwards")
+	testCmd.Flags().Bool("keep-on-failure", false, "with --cleanup, keep the objects of failed tests for debugging")
+	testCmd.Flags().Bool("cluster-diff", false, "show the objects added, changed or removed in the cluster by the test run")
+	testCmd.Flags().String("cluster-diff-file", "", "save the cluster changes as JSON to this file, implies --cluster-diff")
+	testCmd.Flags().Bool("coverage", false, "report which tasks of the role executed across all scenarios")
+	testCmd.Flags().String("coverage-format", "text", "format of the coverage report: text, json, html")
+	testCmd.Flags().String("coverage-file", "", "write the json or html coverage report to this file instead of coverage.<format>")
+	testCmd.Flags().String("report-dir", "", "write JUnit XML and JSON reports of the test run to this directory")
+	testCmd.Flags().Bool("converge", true, "run the role against the environment")
+	testCmd.Flags().Bool("assert", true, "check the objects declared in the role's asserts directory against the cluster")
+	testCmd.Flags().Duration("assert-timeout", 30*time.Second, "how long to wait for an assertion to pass")
+	testCmd.Flags().Bool("idempotence", true, "run the role a second time and fail if any task reports changed")
+	testCmd.Flags().Bool("verify", true, "run the verify playbook or assertions from the role's tests directory")
 }
@@ -18,6 +18,9 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
 )
 
 func ensureVagrantfile() error {
@@ -28,6 +31,42 @@ func ensureVagrantfile() error {
 	return nil
 }
 
+// vagrantfileBox returns the box image and pinned version declared in the
+// Vagrantfile found in dir.
+func vagrantfileBox(dir string) (string, string, error) {
+	content, err := readFile(makePath(dir, "Vagrantfile"))
+	if err != nil {
+		return "", "", err
+	}
+
+	name := ""
+	version := ""
+
+	if m := regexp.MustCompile(`(?m)^\s*IMAGE_NAME\s*=\s*"([^"]*)"`).FindStringSubmatch(content); m != nil {
+		name = m[1]
+	}
+
+	if m := regexp.MustCompile(`(?m)^\s*BOX_VERSION\s*=\s*"([^"]*)"`).FindStringSubmatch(content); m != nil {
+		version = m[1]
+	}
+
+	return name, version, nil
+}
+
+// setVagrantfileBox changes the box image and pinned version declared in the
+// Vagrantfile of the current folder.
+func setVagrantfileBox(name, version string) error {
+	content, err := readFile("Vagrantfile")
+	if err != nil {
+		return err
+	}
+
+	content = regexp.MustCompile(`(?m)^(\s*IMAGE_NAME\s*=\s*)"[^"]*"`).ReplaceAllString(content, fmt.Sprintf(`${1}"%s"`, name))
+	content = regexp.MustCompile(`(?m)^(\s*BOX_VERSION\s*=\s*)"[^"]*"`).ReplaceAllString(content, fmt.Sprintf(`${1}"%s"`, version))
+
+	return os.WriteFile("Vagrantfile", []byte(content), 0644)
+}
+
 func isVagrantEnv() bool {
 	return dirExists("./.vagrant")
 }
@@ -79,3 +118,15 @@ func vagrantUp(name string, provision bool) error {
 
 	return executeExternalProgram("vagrant", param...)
 }
+
+// validateK3sNetwork ensures provider is one of the CNI plug-ins the k3s
+// deployment of a Vagrant environment supports.
+func validateK3sNetwork(provider string) error {
+	validNetworks := []string{"calico", "cilium", "flannel"}
+
+	if slices.Contains(validNetworks, provider) {
+		return nil
+	}
+
+	return fmt.Errorf("'%s' is invalid for the k3s CNI. Valid options: %s", provider, strings.Join(validNetworks, ", "))
+}
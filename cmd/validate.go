@@ -0,0 +1,359 @@
+/*
+Copyright © 2024 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Directories that never contain charts, kustomizations or CRDs of the project.
+var skippedDirs = []string{".git", ".minikube", ".tmp", ".vagrant", "collections"}
+
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// manifestObject is an object of a rendered manifest and the file it came
+// from.
+type manifestObject struct {
+	File   string
+	Object map[string]interface{}
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [chart|kustomization|manifest...]",
+	Short: "Validate charts, kustomizations and manifests against Kubernetes schemas",
+	Long: `Render Helm charts and kustomize directories and validate every object against the OpenAPI
+schemas of the Kubernetes version of the project's k3s_version, plus the custom resource definitions
+found in the project. Without arguments, every chart and kustomization in the project is validated.
+The schemas are downloaded once and cached so validation works without network access.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		version, _ := cmd.Flags().GetString("kubernetes-version")
+
+		if len(version) == 0 {
+			k3s, err := projectK3sVersion()
+			cobra.CheckErr(err)
+
+			version = k3s
+		}
+
+		version = kubernetesVersion(version)
+
+		url, _ := cmd.Flags().GetString("schema-url")
+		offline, _ := cmd.Flags().GetBool("offline")
+
+		validator, err := loadSchemas(version, url, offline)
+		cobra.CheckErr(err)
+
+		crds, err := projectCRDs()
+		cobra.CheckErr(err)
+
+		paths := args
+
+		if len(paths) == 0 {
+			paths, err = discoverManifests()
+			cobra.CheckErr(err)
+		}
+
+		if len(paths) == 0 {
+			cobra.CheckErr(errors.New("no charts or kustomizations found to validate"))
+		}
+
+		var objects []manifestObject
+
+		for _, p := range paths {
+			o, err := renderManifests(cmd, p)
+			cobra.CheckErr(err)
+
+			objects = append(objects, o...)
+		}
+
+		for _, o := range append(crds, objects...) {
+			if o.Object["kind"] == "CustomResourceDefinition" {
+				validator.addCRD(o.Object)
+			}
+		}
+
+		ignoreMissing, _ := cmd.Flags().GetBool("ignore-missing-schemas")
+
+		printMessage(fmt.Sprintf("Validating %d object(s) against Kubernetes %s...", len(objects), version))
+
+		failed := 0
+
+		for _, o := range objects {
+			name := strings.TrimSpace(fmt.Sprintf("%v %s", o.Object["kind"], objectField(o.Object, "metadata", "name")))
+
+			violations, err := validator.Validate(o.Object)
+			if err != nil {
+				if !ignoreMissing {
+					fmt.Printf("  %s %s: %s: %s\n", Red("FAIL"), o.File, name, err)
+					failed++
+				}
+
+				continue
+			}
+
+			for _, v := range violations {
+				fmt.Printf("  %s %s: %s: %s %s\n", Red("FAIL"), o.File, name, v.Field, v.Message)
+			}
+
+			if len(violations) > 0 {
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			cobra.CheckErr(fmt.Errorf("%d of %d object(s) are invalid", failed, len(objects)))
+		}
+
+		printSubMessage(fmt.Sprintf("%d object(s) are valid", len(objects)))
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		ensureRootDirectory()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().String("kubernetes-version", "", "Kubernetes version to validate against (default is the k3s_version of the project)")
+	validateCmd.Flags().String("schema-url", schemaURL, "location of the OpenAPI schemas, %s is replaced by the Kubernetes version")
+	validateCmd.Flags().Bool("offline", false, "only use cached schemas")
+	validateCmd.Flags().Bool("ignore-missing-schemas", false, "skip objects of kinds without a schema")
+	validateCmd.Flags().StringArrayP("values", "f", []string{}, "values file used to render charts (can be repeated)")
+}
+
+func projectK3sVersion() (string, error) {
+	path := makePath("group_vars", "k3s_cluster.yml")
+
+	if !fileExists(path) {
+		return "", errors.New("can't find the k3s_version of the project, use --kubernetes-version")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	var vars struct {
+		Version string `yaml:"k3s_version"`
+	}
+
+	if err := yaml.Unmarshal(content, &vars); err != nil {
+		return "", err
+	}
+
+	if len(vars.Version) == 0 {
+		return "", fmt.Errorf("'%s' doesn't declare the k3s_version, use --kubernetes-version", path)
+	}
+
+	return vars.Version, nil
+}
+
+func isChart(dir string) bool {
+	return fileExists(makePath(dir, "Chart.yaml"))
+}
+
+func isKustomization(dir string) bool {
+	return slices.ContainsFunc(kustomizationFiles, func(f string) bool {
+		return fileExists(makePath(dir, f))
+	})
+}
+
+func isManifestFile(path string) bool {
+	ext := filepath.Ext(path)
+
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// walkProject calls fn for every file and directory of the project that may
+// contain manifests.
+func walkProject(fn func(path string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && path != "." && (slices.Contains(skippedDirs, d.Name()) || strings.HasPrefix(d.Name(), ".")) {
+			return filepath.SkipDir
+		}
+
+		return fn(path, d)
+	})
+}
+
+// discoverManifests returns the charts and kustomizations of the project.
+func discoverManifests() ([]string, error) {
+	var paths []string
+
+	err := walkProject(func(path string, d fs.DirEntry) error {
+		if !d.IsDir() {
+			return nil
+		}
+
+		if isChart(path) || isKustomization(path) {
+			paths = append(paths, path)
+
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	return paths, err
+}
+
+// projectCRDs returns the custom resource definitions found in the project.
+func projectCRDs() ([]manifestObject, error) {
+	var crds []manifestObject
+
+	err := walkProject(func(path string, d fs.DirEntry) error {
+		if d.IsDir() || !isManifestFile(path) {
+			return nil
+		}
+
+		content, err := readFile(path)
+		if err != nil || !strings.Contains(content, "CustomResourceDefinition") {
+			return err
+		}
+
+		// Files that aren't manifests, such as Ansible tasks, are ignored.
+		objects, _ := splitManifests(content, path)
+
+		for _, o := range objects {
+			if o.Object["kind"] == "CustomResourceDefinition" {
+				crds = append(crds, o)
+			}
+		}
+
+		return nil
+	})
+
+	return crds, err
+}
+
+// renderManifests returns the objects of a chart, a kustomization, a manifest
+// file or a directory of manifest files.
+func renderManifests(cmd *cobra.Command, path string) ([]manifestObject, error) {
+	switch {
+	case isChart(path):
+		chart, err := readChart(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := chartDependencies(path, chart); err != nil {
+			return nil, err
+		}
+
+		params := []string{"template", "k8s-dev", path, "--include-crds"}
+
+		values, _ := cmd.Flags().GetStringArray("values")
+
+		for _, v := range values {
+			params = append(params, "--values", v)
+		}
+
+		output, err := executeCommandOutput("helm", params...)
+		if err != nil {
+			return nil, err
+		}
+
+		objects, err := splitManifests(output, path)
+
+		for i := range objects {
+			if objects[i].File != path {
+				objects[i].File = makePath(filepath.Dir(path), objects[i].File)
+			}
+		}
+
+		return objects, err
+	case isKustomization(path):
+		output, err := executeCommandOutput("kubectl", "kustomize", path)
+		if err != nil {
+			return nil, err
+		}
+
+		return splitManifests(output, path)
+	case dirExists(path):
+		var objects []manifestObject
+
+		files, _ := filepath.Glob(makePath(path, "*"))
+
+		for _, f := range files {
+			if !isManifestFile(f) {
+				continue
+			}
+
+			o, err := renderManifests(cmd, f)
+			if err != nil {
+				return nil, err
+			}
+
+			objects = append(objects, o...)
+		}
+
+		return objects, nil
+	default:
+		content, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return splitManifests(content, path)
+	}
+}
+
+// splitManifests splits a manifest into its objects, using the source comment
+// added by helm as the file of an object when there is one.
+func splitManifests(manifest, file string) ([]manifestObject, error) {
+	var objects []manifestObject
+
+	source := regexp.MustCompile(`(?m)^# Source: (.+)$`)
+
+	for _, document := range regexp.MustCompile(`(?m)^---.*$`).Split(manifest, -1) {
+		var object map[string]interface{}
+
+		if err := yaml.Unmarshal([]byte(document), &object); err != nil {
+			return objects, fmt.Errorf("%s: %w", file, err)
+		}
+
+		if len(object) == 0 {
+			continue
+		}
+
+		o := manifestObject{
+			File:   file,
+			Object: object,
+		}
+
+		if m := source.FindStringSubmatch(document); m != nil {
+			o.File = strings.TrimSpace(m[1])
+		}
+
+		objects = append(objects, o)
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,241 @@
+/*
+Copyright © 2023 Julian Easterling <julian@julianscorner.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+var watchedRoleDirs = []string{"defaults", "files", "handlers", "meta", "tasks", "templates", "vars"}
+
+// watchRoleTest converges the role of t every time one of its files changes
+// until interrupted. The scenario is prepared once before watching and cleaned
+// up once when interrupted. Changes are debounced so a burst of saves results
+// in a single run. With tags, a change limited to task files only runs the
+// tasks tagged with the names of the changed files.
+func watchRoleTest(t *roleTest, tags bool) error {
+	dir := roleDir(t.Role)
+
+	if len(dir) == 0 {
+		return fmt.Errorf("can't find the '%s' role to watch", t.Role)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	defer signal.Stop(interrupt)
+
+	// Only the status line of each run is printed, the output of Ansible is
+	// written to the test's log.
+	t.Options.Quiet = true
+
+	release, err := t.setup()
+	if err != nil {
+		return err
+	}
+
+	defer release()
+
+	if len(t.Scenario.Prepare) > 0 {
+		if p := t.phase("prepare", t.prepare); p.Status == phaseFailed {
+			return fmt.Errorf("preparing '%s' failed: %s", t.Name(), p.Message)
+		}
+	}
+
+	if len(t.Scenario.Cleanup) > 0 {
+		defer func() {
+			if p := t.phase("cleanup", t.cleanup); p.Status == phaseFailed {
+				printSubMessage(fmt.Sprintf("cleaning up '%s' failed: %s", t.Name(), p.Message))
+			}
+		}()
+	}
+
+	printMessage(fmt.Sprintf("Watching '%s' for changes, press Ctrl+C to stop...", dir))
+
+	files := snapshotFiles(dir)
+	watchConverge(t, nil)
+
+	const debounce = 750 * time.Millisecond
+
+	var pending []string
+	var last time.Time
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-interrupt:
+			fmt.Println()
+			return nil
+		case <-ticker.C:
+			current := snapshotFiles(dir)
+
+			if changed := changedFiles(files, current); len(changed) > 0 {
+				for _, c := range changed {
+					if !slices.Contains(pending, c) {
+						pending = append(pending, c)
+					}
+				}
+
+				files = current
+				last = time.Now()
+
+				continue
+			}
+
+			if len(pending) == 0 || time.Since(last) < debounce {
+				continue
+			}
+
+			t.Options.Tags = ""
+
+			if tags {
+				t.Options.Tags = changedTags(dir, pending)
+			}
+
+			watchConverge(t, pending)
+
+			pending = nil
+		}
+	}
+}
+
+// watchConverge converges the role, followed by its assertions when enabled,
+// and prints a single status line.
+func watchConverge(t *roleTest, changed []string) {
+	start := time.Now()
+	result := testResult{Role: t.Role, Scenario: t.Scenario.Name}
+
+	t.run(&result, "converge", t.runConverge)
+
+	if t.Options.Assert {
+		t.run(&result, "assert", t.assert)
+	}
+
+	result.Duration = time.Since(start)
+
+	status := Green("PASSED")
+
+	if !result.Passed() {
+		status = Red(fmt.Sprintf("FAILED (%s)", result.failed()))
+	}
+
+	line := fmt.Sprintf("[%s] %s %s in %s", time.Now().Format("15:04:05"), t.Name(), status, result.Duration.Round(time.Millisecond))
+
+	if len(t.Options.Tags) > 0 {
+		line = fmt.Sprintf("%s, tags: %s", line, t.Options.Tags)
+	}
+
+	if len(changed) > 0 {
+		line = fmt.Sprintf("%s, changed: %s", line, strings.Join(changed, ", "))
+	}
+
+	if !result.Passed() {
+		for _, p := range result.Phases {
+			if p.Status == phaseFailed {
+				for _, task := range p.Tasks {
+					if task.Failed() {
+						fmt.Println(Red(fmt.Sprintf("  %s [%s]: %s", task.Task, task.Host, task.Message)))
+					}
+				}
+
+				if len(p.Tasks) == 0 && len(p.Message) > 0 {
+					fmt.Println(Red(fmt.Sprintf("  %s", p.Message)))
+				}
+			}
+		}
+	}
+
+	fmt.Println(line)
+}
+
+// snapshotFiles returns the modification time of every file in the parts of
+// a role that affect a converge.
+func snapshotFiles(dir string) map[string]time.Time {
+	files := map[string]time.Time{}
+
+	for _, sub := range watchedRoleDirs {
+		_ = filepath.WalkDir(makePath(dir, sub), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+
+			if info, err := d.Info(); err == nil {
+				files[path] = info.ModTime()
+			}
+
+			return nil
+		})
+	}
+
+	return files
+}
+
+func changedFiles(before, after map[string]time.Time) []string {
+	var changed []string
+
+	for path, modified := range after {
+		if previous, ok := before[path]; !ok || !previous.Equal(modified) {
+			changed = append(changed, path)
+		}
+	}
+
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+
+	sort.Strings(changed)
+
+	return changed
+}
+
+// changedTags returns the names of the changed task files for use as tags,
+// or nothing when any other kind of file changed and the whole role needs to
+// run.
+func changedTags(dir string, changed []string) string {
+	var tags []string
+
+	tasks := makePath(dir, "tasks") + string(filepath.Separator)
+
+	for _, c := range changed {
+		if !strings.HasPrefix(c, tasks) {
+			return ""
+		}
+
+		tag := strings.TrimSuffix(filepath.Base(c), filepath.Ext(c))
+
+		if tag == "main" {
+			return ""
+		}
+
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	return strings.Join(tags, ",")
+}